@@ -0,0 +1,86 @@
+package lmgate
+
+import (
+	"sync"
+	"time"
+)
+
+// Adaptive chunk sizing.
+//
+// The chunk size follows the measured throughput so that one chunk
+// takes about targetChunkTime to send: slow or throttled links get
+// small chunks (smooth traffic, cheap retries), fast links get big ones.
+// A failed send halves the size and the chunk is retried.
+//
+// NOTE: Chunk boundaries change during the upload, so Total is only
+// known at EOF (like a stream) and resume is not possible.
+
+const (
+	minAdaptiveChunk = int64(256 * 1024)
+	maxAdaptiveChunk = int64(32 * 1024 * 1024)
+
+	// targetChunkTime is how long sending one chunk should take.
+	targetChunkTime = 2 * time.Second
+
+	// maxChunkAttempts is how many times a chunk is sent in adaptive mode.
+	maxChunkAttempts = 3
+)
+
+// chunkTuner picks the size of the next chunk.
+//
+// NOTE: Safe for concurrent use. A nil tuner means fixed-size chunks.
+type chunkTuner struct {
+	mu         sync.Mutex
+	size       int64
+	throughput float64 // bytes per second, moving average per worker
+}
+
+// newChunkTuner starts from the configured chunk size.
+func newChunkTuner(initial int64) *chunkTuner {
+	return &chunkTuner{size: clampChunk(initial)}
+}
+
+// Size returns the size of the next chunk.
+func (t *chunkTuner) Size() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// Success records a chunk of n bytes sent in d.
+func (t *chunkTuner) Success(n int, d time.Duration) {
+	if t == nil || n <= 0 || d <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rate := float64(n) / d.Seconds()
+	if t.throughput == 0 {
+		t.throughput = rate
+	} else {
+		t.throughput = 0.7*t.throughput + 0.3*rate
+	}
+
+	// التغيير تدريجي: الحجم لا يتضاعف أو ينقص للنصف بأكثر من خطوة واحدة
+	target := int64(t.throughput * targetChunkTime.Seconds())
+	target = max(t.size/2, min(target, t.size*2))
+	t.size = clampChunk(target)
+}
+
+// Failure halves the chunk size after a failed send.
+func (t *chunkTuner) Failure() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.size = clampChunk(t.size / 2)
+}
+
+func clampChunk(size int64) int64 {
+	return max(minAdaptiveChunk, min(size, maxAdaptiveChunk))
+}
@@ -0,0 +1,79 @@
+package lmgate
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChunkTunerFollowsThroughput(t *testing.T) {
+	tuner := newChunkTuner(1 << 20)
+
+	// 10MB/s يعني 20MB في targetChunkTime، لكن الحجم يتضاعف مرة واحدة فقط
+	tuner.Success(1<<20, 100*time.Millisecond)
+	if got := tuner.Size(); got != 2<<20 {
+		t.Fatalf("after fast chunk size %d, want %d", got, 2<<20)
+	}
+
+	tuner.Failure()
+	if got := tuner.Size(); got != 1<<20 {
+		t.Fatalf("after failure size %d, want %d", got, 1<<20)
+	}
+
+	for range 10 {
+		tuner.Failure()
+	}
+	if got := tuner.Size(); got != minAdaptiveChunk {
+		t.Fatalf("size %d, want minimum %d", got, minAdaptiveChunk)
+	}
+
+	if got := newChunkTuner(1 << 40).Size(); got != maxAdaptiveChunk {
+		t.Fatalf("initial size %d, want maximum %d", got, maxAdaptiveChunk)
+	}
+}
+
+// failOnceSender يرفض أول رسالة ثم يقبل الباقي
+type failOnceSender struct {
+	recordingSender
+	failed bool
+}
+
+func (s *failOnceSender) Send(msg ChunkMessage) error {
+	if !s.failed {
+		s.failed = true
+		return errors.New("connection reset")
+	}
+	return s.recordingSender.Send(msg)
+}
+
+func TestAdaptiveUploadRetriesChunk(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+	sender := &failOnceSender{}
+
+	sent, err := UploadFileWithOptions(writeTempFile(t, "cap.pcap", data), UploadOptions{ChunkSize: 1 << 20, Adaptive: true}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 || len(sender.msgs) != 2 {
+		t.Fatalf("sent %d chunks in %d messages, want 1 in 2", sent, len(sender.msgs))
+	}
+	if eof := sender.msgs[1]; !eof.IsEOF || eof.Total != 1 {
+		t.Fatalf("EOF %+v, want Total 1", eof)
+	}
+}
+
+func TestUploadFileRateLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3000)
+	sender := &recordingSender{}
+
+	// 2000 بايت من الـ burst، والباقي بعد نصف ثانية
+	start := time.Now()
+	_, err := UploadFileWithOptions(writeTempFile(t, "cap.pcap", data), UploadOptions{ChunkSize: 1000, RateLimit: 2000}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("3000 bytes at 2000 B/s took only %v", d)
+	}
+}
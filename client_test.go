@@ -0,0 +1,120 @@
+package lmgate
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingSender keeps every sent message.
+type recordingSender struct {
+	mu   sync.Mutex
+	msgs []ChunkMessage
+}
+
+func (s *recordingSender) Send(msg ChunkMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg.Data = append([]byte(nil), msg.Data...)
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUploadFileRejectsEmptyFile(t *testing.T) {
+	path := writeTempFile(t, "empty.pcap", nil)
+	sender := &recordingSender{}
+
+	sent, err := UploadFileWithOptions(path, UploadOptions{ChunkSize: 1024}, sender)
+	if !errors.Is(err, ErrEmptyFile) {
+		t.Fatalf("got %v, want ErrEmptyFile", err)
+	}
+	if sent != 0 || len(sender.msgs) != 0 {
+		t.Fatalf("nothing must be sent for an empty file, sent %d messages", len(sender.msgs))
+	}
+}
+
+func TestValidateMessageRejectsEmptyEOF(t *testing.T) {
+	err := ValidateMessage(ChunkMessage{FileID: "abc", IsEOF: true})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("got %v, want ErrInvalidMessage", err)
+	}
+
+	if err := ValidateMessage(ChunkMessage{FileID: "abc", Total: 1, IsEOF: true, FileDigest: "digest"}); err != nil {
+		t.Fatalf("EOF with chunks rejected: %v", err)
+	}
+}
+
+// slowSender records messages and how many Send calls overlapped.
+type slowSender struct {
+	recordingSender
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (s *slowSender) Send(msg ChunkMessage) error {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		top := s.maxInFlight.Load()
+		if n <= top || s.maxInFlight.CompareAndSwap(top, n) {
+			break
+		}
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	return s.recordingSender.Send(msg)
+}
+
+func TestUploadFileStreamsWithBoundedParallelism(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 195) // 1950 bytes = 20 chunks of 100
+	path := writeTempFile(t, "cap.pcap", data)
+	sender := &slowSender{}
+
+	sent, err := UploadFileWithOptions(path, UploadOptions{ChunkSize: 100, Parallelism: 3}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 20 {
+		t.Fatalf("sent %d chunks, want 20", sent)
+	}
+	if top := sender.maxInFlight.Load(); top > 3 {
+		t.Fatalf("%d chunks in flight, parallelism is 3", top)
+	}
+
+	msgs := sender.msgs
+	if len(msgs) != 21 || !msgs[20].IsEOF || msgs[20].Total != 20 {
+		t.Fatalf("want 20 chunks followed by EOF with Total 20, got %d messages", len(msgs))
+	}
+
+	// Total is known from the file size before reading
+	chunks := msgs[:20]
+	for _, msg := range chunks {
+		if msg.IsEOF || msg.Total != 20 {
+			t.Fatalf("chunk %d has Total %d (EOF %t)", msg.ChunkID, msg.Total, msg.IsEOF)
+		}
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].ChunkID < chunks[j].ChunkID })
+	var got []byte
+	for _, msg := range chunks {
+		got = append(got, msg.Data...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("chunks do not rebuild the file")
+	}
+}
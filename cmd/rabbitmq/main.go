@@ -1,7 +1,9 @@
 package main
 
 import (
+	lmgate "LM-Gate"
 	"LM-Gate/internal/infra"
+	"context"
 	"log/slog"
 	"os"
 	"time"
@@ -34,6 +36,65 @@ func main() {
 	}
 	defer rabbit.Close()
 
+	if os.Getenv(infra.SenderKeyEnv) == "" {
+		logger.Warn("⚠️ No sender key, retried chunks lose their uploader on restart", slog.String("env", infra.SenderKeyEnv))
+	}
+
+	// مسارات التخزين والحصة والمفاتيح من متغيرات البيئة
+	chunks, err := lmgate.DefaultChunkManager()
+	if err != nil {
+		logger.Error("❌ Failed to configure chunk storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// استقبال قطع الملفات القادمة من أداة lm وتجميعها
+	if err := chunks.ReceiveChunks(rabbit, lmgate.ChunkQueueName); err != nil {
+		logger.Error("❌ Failed to start chunk receiver", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// الرد على استعلامات العميل عن القطع المستلمة (استكمال الرفع)
+	if err := chunks.ServeManifests(rabbit); err != nil {
+		logger.Error("❌ Failed to start manifest service", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// فحص وجود الملف مسبقاً (نفس الـ hash) قبل الرفع
+	if err := chunks.ServeLookups(rabbit); err != nil {
+		logger.Error("❌ Failed to start lookup service", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// إخبار العميل بأنواع الضغط المدعومة
+	if err := lmgate.ServeCapabilities(rabbit); err != nil {
+		logger.Error("❌ Failed to start capabilities service", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// عرض استهلاك القرص للمشغّلين
+	if err := chunks.ServeQuotaUsage(rabbit); err != nil {
+		logger.Error("❌ Failed to start quota service", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// حذف الرفع المتروك (بدون EOF) بعد LM_UPLOAD_TTL من القرص و Redis
+	var redisStore *infra.RedisService
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		redisStore = infra.NewRedisService(addr)
+		if err := redisStore.Ping(); err != nil {
+			logger.Warn("⚠️ Redis not reachable, janitor will clean disk only", slog.Any("error", err))
+			redisStore = nil
+		}
+	}
+
+	janitor, err := lmgate.JanitorFromEnv(chunks, redisStore)
+	if err != nil {
+		logger.Error("❌ Failed to configure janitor", slog.Any("error", err))
+		os.Exit(1)
+	}
+	janitor.Publish = lmgate.PublishTo(rabbit, lmgate.AbandonedQueueName)
+	go janitor.Run(context.Background())
+
 	logger.Info("🚀 RabbitMQ Worker is running and waiting...")
 	select {}
 }
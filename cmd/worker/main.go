@@ -1,6 +1,9 @@
 package main
 
 import (
+	"LM-Gate/internal/infra"
+	"LM-Gate/internal/logic"
+	"LM-Gate/internal/work"
 	"log/slog"
 	"os"
 	"time"
@@ -12,9 +15,30 @@ func main() {
 
 	logger.Info("🚀 System Monitor is running...")
 
-	// يمكن وضع منطق المراقبة أو التنسيق هنا
-	logger.Info("📡 Watching all events...")
-	for {
-		time.Sleep(time.Hour)
+	var rabbit *infra.RabbitClient
+	var err error
+	for i := 1; i <= 20; i++ {
+		rabbit, err = infra.NewRabbitClient(logic.RabbitURL())
+		if err == nil {
+			logger.Info("✅ Connected to RabbitMQ")
+			break
+		}
+		logger.Warn("⏳ RabbitMQ not ready", slog.Int("attempt", i), slog.Any("error", err))
+		time.Sleep(1 * time.Second)
+	}
+
+	if err != nil {
+		logger.Error("❌ Failed to connect to RabbitMQ after multiple attempts")
+		os.Exit(1)
 	}
+	defer rabbit.Close()
+
+	// معالجة الملفات المرفوعة وتحديث حالة المهام (GET /jobs/:id)
+	if err := work.RegisterPcapUploaded(rabbit, logic.DefaultJobStore(), logic.DefaultPublisher()); err != nil {
+		logger.Error("❌ Failed to consume pcap events", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	logger.Info("📡 Watching all events...")
+	select {}
 }
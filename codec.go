@@ -0,0 +1,164 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Chunk payload codecs.
+//
+// NOTE: CodecNone means Data holds the raw file bytes.
+// zstd compresses better than gzip at a lower CPU cost, which matters
+// on slow sensor links. The server advertises its codecs through
+// SupportedCodecs, so a client never sends one an older server
+// cannot decode.
+const (
+	CodecNone = ""
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// CapabilitiesQueueName is the RabbitMQ queue used by the client
+// to ask which codecs the server can decode.
+const CapabilitiesQueueName = "server_capabilities_queue"
+
+// MaxChunkSize is the largest chunk (before compression and encryption)
+// the server accepts. The client refuses a larger --chunk-size.
+const MaxChunkSize = 64 * 1024 * 1024
+
+// maxDecodedChunk limits the decompressed size of one chunk
+// so a small malicious payload cannot fill memory (zip bomb).
+//
+// NOTE: Tied to MaxChunkSize, every valid chunk decodes below it.
+const maxDecodedChunk = MaxChunkSize
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Capabilities describes what the server supports.
+type Capabilities struct {
+	Codecs []string `json:"codecs"`
+}
+
+// SupportedCodecs returns the codecs this build can encode and decode.
+func SupportedCodecs() []string {
+	return []string{CodecZstd, CodecGzip}
+}
+
+// zstdEncoder and zstdDecoder are shared by all chunks;
+// EncodeAll and DecodeAll are safe for concurrent use.
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedChunk))
+	})
+)
+
+// isKnownCodec reports whether a codec can be decoded.
+func isKnownCodec(codec string) bool {
+	return codec == CodecNone || slices.Contains(SupportedCodecs(), codec)
+}
+
+// CompressChunk encodes a chunk payload with the given codec.
+func CompressChunk(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecZstd:
+		zw, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return zw.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, codec)
+	}
+}
+
+// DecompressChunk decodes a chunk payload stored with the given codec.
+func DecompressChunk(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		out, err := io.ReadAll(io.LimitReader(zr, maxDecodedChunk+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > maxDecodedChunk {
+			return nil, fmt.Errorf("decoded chunk is larger than %d bytes", maxDecodedChunk)
+		}
+		return out, nil
+	case CodecZstd:
+		zr, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		// WithDecoderMaxMemory: DecodeAll يرفض ما يتجاوز maxDecodedChunk
+		out, err := zr.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decoded chunk is larger than %d bytes or corrupted: %w", maxDecodedChunk, err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, codec)
+	}
+}
+
+// CompressMessage replaces the message payload with its encoded form.
+//
+// NOTE: When compression does not make the chunk smaller,
+// the raw payload is kept and Codec stays CodecNone.
+// Checksum always covers the payload as sent.
+func CompressMessage(msg ChunkMessage, codec string) (ChunkMessage, error) {
+	if codec == CodecNone {
+		return msg, nil
+	}
+
+	data, err := CompressChunk(codec, msg.Data)
+	if err != nil {
+		return msg, err
+	}
+
+	if len(data) >= len(msg.Data) {
+		return msg, nil
+	}
+
+	msg.Data = data
+	msg.Codec = codec
+	msg.Checksum = ChunkChecksum(data)
+	return msg, nil
+}
+
+// ServeCapabilities answers codec negotiation requests over RabbitMQ.
+func ServeCapabilities(rabbit *infra.RabbitClient) error {
+	return rabbit.ServeRPC(CapabilitiesQueueName, func([]byte) ([]byte, error) {
+		return json.Marshal(Capabilities{Codecs: SupportedCodecs()})
+	})
+}
@@ -0,0 +1,96 @@
+package lmgate
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestChunkCodecRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("packet "), 1000)
+
+	for _, codec := range []string{CodecNone, CodecGzip, CodecZstd} {
+		t.Run("codec="+codec, func(t *testing.T) {
+			encoded, err := CompressChunk(codec, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecompressChunk(codec, encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Fatal("decoded chunk differs from the original")
+			}
+		})
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	if _, err := CompressChunk("lz4", []byte("x")); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("compress: got %v, want ErrUnknownCodec", err)
+	}
+	if _, err := DecompressChunk("lz4", []byte("x")); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("decompress: got %v, want ErrUnknownCodec", err)
+	}
+}
+
+func TestDecompressChunkLimit(t *testing.T) {
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		t.Run("codec="+codec, func(t *testing.T) {
+			bomb, err := CompressChunk(codec, make([]byte, maxDecodedChunk+1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := DecompressChunk(codec, bomb); err == nil {
+				t.Fatal("chunk larger than maxDecodedChunk was decoded")
+			}
+		})
+	}
+}
+
+func TestServerAdvertisesZstd(t *testing.T) {
+	if !isKnownCodec(CodecZstd) {
+		t.Fatal("zstd is not in SupportedCodecs")
+	}
+}
+
+func TestCompressMessageKeepsRawWhenNotSmaller(t *testing.T) {
+	msg := BuildChunkMessage("file", 0, 1, []byte{0x01})
+
+	got, err := CompressMessage(msg, CodecGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Codec != CodecNone || !bytes.Equal(got.Data, msg.Data) {
+		t.Fatal("incompressible chunk must be sent raw")
+	}
+
+	msg = BuildChunkMessage("file", 0, 1, bytes.Repeat([]byte{0}, 4096))
+	got, err = CompressMessage(msg, CodecGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Codec != CodecGzip || got.Checksum != ChunkChecksum(got.Data) {
+		t.Fatal("compressed chunk must carry the codec and the checksum of the sent bytes")
+	}
+}
+
+func TestValidateChunkSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		ok   bool
+	}{
+		{0, false},
+		{-1, false},
+		{1, true},
+		{MaxChunkSize, true},
+		{MaxChunkSize + 1, false},
+	}
+
+	for _, tt := range tests {
+		if err := validateChunkSize(tt.size); (err == nil) != tt.ok {
+			t.Errorf("validateChunkSize(%d) = %v, want ok=%t", tt.size, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/infra"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+)
+
+// LookupQueueName is the RabbitMQ queue used by the client to ask
+// whether the server already has a capture with the same content.
+const LookupQueueName = "file_lookup_queue"
+
+// LookupRequest asks the server about a file before uploading it.
+//
+// NOTE: FileID is the content hash of the file (see GenerateFileIDWith).
+type LookupRequest struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+}
+
+// LookupReply tells the client whether the upload can be skipped.
+type LookupReply struct {
+	Exists bool   `json:"exists"`
+	Path   string `json:"path,omitempty"`
+}
+
+// CaptureInfo is stored next to every assembled capture
+// as uploads/<FileID>.json.
+//
+// NOTE: Names holds every original file name that was uploaded
+// with the same content, so duplicates are linked, not stored twice.
+type CaptureInfo struct {
+	FileID string               `json:"file_id"`
+	Size   int64                `json:"size"`
+	Names  []string             `json:"names"`
+	Format *infra.CaptureFormat `json:"format,omitempty"`
+}
+
+// ================= Server side =================
+
+// LookupCapture checks whether a capture already exists.
+// When it does, the requested file name is linked to it.
+func (m *ChunkManager) LookupCapture(req LookupRequest) (LookupReply, error) {
+	if !validFileID(req.FileID) {
+		return LookupReply{}, ErrInvalidMessage
+	}
+
+	path := m.capturePath(req.FileID)
+	if _, err := m.FS.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return LookupReply{Exists: false}, nil
+	} else if err != nil {
+		return LookupReply{}, err
+	}
+
+	if err := m.linkCaptureName(req.FileID, req.FileName); err != nil {
+		return LookupReply{}, err
+	}
+
+	return LookupReply{Exists: true, Path: path}, nil
+}
+
+// LookupCapture checks for a capture using the default manager.
+func LookupCapture(req LookupRequest) (LookupReply, error) {
+	m, err := defaultManager()
+	if err != nil {
+		return LookupReply{}, err
+	}
+	return m.LookupCapture(req)
+}
+
+// linkCaptureName adds an original file name to the capture metadata.
+func (m *ChunkManager) linkCaptureName(fileID string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info := CaptureInfo{FileID: fileID}
+
+	data, err := m.FS.ReadFile(m.captureInfoPath(fileID))
+	if err == nil {
+		if err := json.Unmarshal(data, &info); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if stat, err := m.FS.Stat(m.capturePath(fileID)); err == nil {
+		info.Size = stat.Size()
+	}
+
+	// الصيغة تُقرأ مرة واحدة من أول بايتات الملف المجمّع
+	if info.Format == nil {
+		if format, err := m.sniffCapture(fileID); err == nil {
+			info.Format = &format
+		}
+	}
+
+	if name != "" && !slices.Contains(info.Names, name) {
+		info.Names = append(info.Names, name)
+		slices.Sort(info.Names)
+	}
+
+	data, err = json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return m.FS.WriteFile(m.captureInfoPath(fileID), data)
+}
+
+// sniffCapture detects the format of an assembled capture.
+func (m *ChunkManager) sniffCapture(fileID string) (infra.CaptureFormat, error) {
+	file, err := m.FS.Open(m.capturePath(fileID))
+	if err != nil {
+		return infra.CaptureFormat{}, err
+	}
+	defer file.Close()
+
+	format, _, err := infra.SniffCapture(file)
+	return format, err
+}
+
+// ServeLookups answers LookupRequest messages over RabbitMQ.
+func (m *ChunkManager) ServeLookups(rabbit *infra.RabbitClient) error {
+	return rabbit.ServeRPC(LookupQueueName, func(body []byte) ([]byte, error) {
+		var req LookupRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+
+		reply, err := m.LookupCapture(req)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(reply)
+	})
+}
@@ -0,0 +1,76 @@
+package lmgate
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"LM-Gate/internal/infra"
+)
+
+// managerSender delivers messages straight to a ChunkManager
+// and answers lookups like the server.
+type managerSender struct {
+	m    *ChunkManager
+	sent int
+}
+
+func (s *managerSender) Send(msg ChunkMessage) error {
+	s.sent++
+	_, err := s.m.OnMessage(msg)
+	return err
+}
+
+func (s *managerSender) Lookup(req LookupRequest) (*LookupReply, error) {
+	reply, err := s.m.LookupCapture(req)
+	return &reply, err
+}
+
+func TestFileIDFromContent(t *testing.T) {
+	a := GenerateFileID(writeTempFile(t, "a.pcap", []byte("same bytes")))
+	b := GenerateFileID(writeTempFile(t, "b.pcap", []byte("same bytes")))
+	c := GenerateFileID(writeTempFile(t, "a.pcap", []byte("other byte")))
+
+	if a == "" || a != b {
+		t.Fatalf("same content got %q and %q", a, b)
+	}
+	if a == c {
+		t.Fatal("different content with the same name and size got the same ID")
+	}
+}
+
+func TestUploadSkipsExistingCapture(t *testing.T) {
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	sender := &managerSender{m: m}
+	data := []byte("capture bytes")
+	opts := UploadOptions{ChunkSize: 4, SkipExisting: true}
+
+	first, err := UploadFileResult(writeTempFile(t, "monday.pcap", data), opts, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Sent == 0 {
+		t.Fatal("first upload sent nothing")
+	}
+
+	sender.sent = 0
+	second, err := UploadFileResult(writeTempFile(t, "copy.pcap", data), opts, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.FileID != first.FileID || second.Sent != 0 || sender.sent != 0 {
+		t.Fatalf("duplicate upload sent %d messages (ID %s, first %s)", sender.sent, second.FileID, first.FileID)
+	}
+
+	raw, err := m.FS.ReadFile(m.captureInfoPath(first.FileID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info CaptureInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"copy.pcap", "monday.pcap"}; !reflect.DeepEqual(info.Names, want) {
+		t.Fatalf("names %v, want %v", info.Names, want)
+	}
+}
@@ -0,0 +1,301 @@
+package lmgate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+// Chunk encryption.
+//
+// Every upload gets a random per-file key (data key). Chunks are sealed
+// with AES-256-GCM under the data key, and the data key itself is wrapped
+// with a configured key (key encryption key) identified by KeyID.
+//
+// Nothing derived from the plaintext travels in clear: FileID and
+// FileDigest are keyed hashes (see FileHash) and RawChecksum is an HMAC
+// under the file key (see FileKey.Checksum).
+//
+// NOTE: Key rotation: add a new key to LM_ENCRYPTION_KEYS and point
+// LM_ENCRYPTION_KEY_ID at it. Old keys stay in the list until every
+// upload wrapped with them has been assembled. FileIDs are derived from
+// the active key, so a capture uploaded again after a rotation is not
+// deduplicated against its earlier copy.
+
+const (
+	// dataKeySize is the size of a per-file data key (AES-256).
+	dataKeySize = 32
+
+	// sealOverhead is what seal adds to a payload (GCM nonce and tag).
+	sealOverhead = 12 + 16
+
+	// Labels of the keys derived with deriveKey.
+	fileIDLabel   = "lmgate file id"
+	checksumLabel = "lmgate chunk checksum"
+)
+
+var (
+	ErrUnknownKey    = errors.New("unknown encryption key")
+	ErrDecryptFailed = errors.New("chunk decryption failed")
+)
+
+// Keyring holds the key encryption keys by ID.
+type Keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// LoadKeyring reads the keyring from the environment.
+//
+// LM_ENCRYPTION_KEYS is a comma separated list of id:base64key
+// (32 bytes each), LM_ENCRYPTION_KEY_ID selects the key used for
+// new uploads (defaults to the first one).
+//
+// NOTE: Returns nil without error when no keys are configured.
+func LoadKeyring() (*Keyring, error) {
+	return ParseKeyring(os.Getenv("LM_ENCRYPTION_KEYS"), os.Getenv("LM_ENCRYPTION_KEY_ID"))
+}
+
+// ParseKeyring builds a keyring from its text form (see LoadKeyring).
+func ParseKeyring(spec string, activeID string) (*Keyring, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	kr := &Keyring{keys: make(map[string][]byte)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry %q (want id:base64key)", entry)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", id, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("invalid key %s: want %d bytes, got %d", id, dataKeySize, len(key))
+		}
+
+		kr.keys[id] = key
+		if kr.activeID == "" {
+			kr.activeID = id
+		}
+	}
+
+	if activeID != "" {
+		if _, ok := kr.keys[activeID]; !ok {
+			return nil, fmt.Errorf("%w: active key %s", ErrUnknownKey, activeID)
+		}
+		kr.activeID = activeID
+	}
+
+	return kr, nil
+}
+
+// FileHash returns the hash that names an upload (FileID) and checks
+// the assembled file (FileDigest).
+//
+// NOTE: With keys configured it is an HMAC-SHA256 under a key derived
+// from the active key, so whoever sees the queue or the server storage
+// cannot tell whether a known capture was uploaded. Without keys it is
+// plain SHA-256; the chunks then carry the plaintext anyway.
+func (kr *Keyring) FileHash() hash.Hash {
+	if kr == nil {
+		return sha256.New()
+	}
+	h, _ := kr.fileHash(kr.activeID)
+	return h
+}
+
+// fileHash is FileHash for the key an upload was encrypted with.
+func (kr *Keyring) fileHash(keyID string) (hash.Hash, error) {
+	kek, err := kr.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return hmac.New(sha256.New, deriveKey(kek, fileIDLabel)), nil
+}
+
+// key returns a key encryption key by ID.
+func (kr *Keyring) key(keyID string) ([]byte, error) {
+	if kr == nil {
+		return nil, fmt.Errorf("%w: %s (no keys configured)", ErrUnknownKey, keyID)
+	}
+
+	kek, ok := kr.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return kek, nil
+}
+
+// FileKey is the data key of one upload.
+type FileKey struct {
+	KeyID      string
+	WrappedKey []byte
+	key        []byte
+	macKey     []byte // RawChecksum key, derived from key
+	fileID     string
+}
+
+// NewFileKey creates a random data key for a file
+// and wraps it with the active key of the keyring.
+func (kr *Keyring) NewFileKey(fileID string) (*FileKey, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(kr.keys[kr.activeID], key, []byte(fileID))
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileKey(fileID, kr.activeID, wrapped, key), nil
+}
+
+// OpenFileKey unwraps the data key of an earlier upload of fileID.
+//
+// NOTE: A resumed upload reuses the key recorded in the server manifest,
+// so its chunk checksums match the chunks the server already has.
+func (kr *Keyring) OpenFileKey(fileID string, keyID string, wrapped []byte) (*FileKey, error) {
+	kek, err := kr.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := open(kek, wrapped, []byte(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: file=%s: bad wrapped key", ErrDecryptFailed, fileID)
+	}
+
+	return newFileKey(fileID, keyID, wrapped, key), nil
+}
+
+func newFileKey(fileID string, keyID string, wrapped []byte, key []byte) *FileKey {
+	return &FileKey{
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		key:        key,
+		macKey:     deriveKey(key, checksumLabel),
+		fileID:     fileID,
+	}
+}
+
+// Checksum returns the RawChecksum of a plaintext chunk.
+//
+// NOTE: An HMAC-SHA256 under the file key, so it can be sent and
+// stored in clear. A nil file key (no encryption) gives ChunkChecksum.
+func (fk *FileKey) Checksum(data []byte) string {
+	if fk == nil {
+		return ChunkChecksum(data)
+	}
+
+	mac := hmac.New(sha256.New, fk.macKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptMessage seals the message payload with the file key.
+//
+// NOTE: FileID, ChunkID and Codec are bound as additional data, so a
+// chunk cannot be moved to another position or another file, or be
+// decoded with another codec, unnoticed.
+// Checksum is updated to cover the ciphertext.
+func (fk *FileKey) EncryptMessage(msg ChunkMessage) (ChunkMessage, error) {
+	if fk == nil {
+		return msg, nil
+	}
+
+	data, err := seal(fk.key, msg.Data, chunkAAD(msg.FileID, msg.ChunkID, msg.Codec))
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Data = data
+	msg.KeyID = fk.KeyID
+	msg.WrappedKey = fk.WrappedKey
+	msg.Checksum = ChunkChecksum(data)
+	return msg, nil
+}
+
+// DecryptChunk unwraps the file key and opens one stored chunk.
+//
+// NOTE: Returns ErrDecryptFailed when the chunk was tampered with.
+func (kr *Keyring) DecryptChunk(fileID string, chunkID int, info ChunkInfo, data []byte) ([]byte, error) {
+	fk, err := kr.OpenFileKey(fileID, info.KeyID, info.WrappedKey)
+	if err != nil {
+		if errors.Is(err, ErrDecryptFailed) {
+			return nil, fmt.Errorf("%w: file=%s chunk=%d: bad wrapped key", ErrDecryptFailed, fileID, chunkID)
+		}
+		return nil, err
+	}
+
+	plain, err := open(fk.key, data, chunkAAD(fileID, chunkID, info.Codec))
+	if err != nil {
+		return nil, fmt.Errorf("%w: file=%s chunk=%d", ErrDecryptFailed, fileID, chunkID)
+	}
+
+	return plain, nil
+}
+
+// chunkAAD is the additional authenticated data of a chunk.
+func chunkAAD(fileID string, chunkID int, codec string) []byte {
+	return []byte(fmt.Sprintf("%s:%d:%s", fileID, chunkID, codec))
+}
+
+// deriveKey derives a key for one purpose (label) from a parent key.
+func deriveKey(parent []byte, label string) []byte {
+	mac := hmac.New(sha256.New, parent)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce.
+func seal(key []byte, plain []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plain, aad), nil
+}
+
+// open reverses seal.
+func open(key []byte, sealed []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,190 @@
+package lmgate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+	"testing"
+
+	"LM-Gate/internal/infra"
+)
+
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, dataKeySize))
+	kr, err := ParseKeyring("k1:"+key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	kr := testKeyring(t)
+	fk, err := kr.NewFileKey("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := []byte("packet bytes")
+	msg, err := fk.EncryptMessage(ChunkMessage{FileID: "file", ChunkID: 2, Codec: CodecNone, Data: plain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(msg.Data, plain) {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+
+	info := ChunkInfo{Codec: msg.Codec, KeyID: msg.KeyID, WrappedKey: msg.WrappedKey}
+	got, err := kr.DecryptChunk("file", 2, info, msg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("got %q, want %q", got, plain)
+	}
+}
+
+func TestDecryptRejectsChangedBinding(t *testing.T) {
+	kr := testKeyring(t)
+	fk, err := kr.NewFileKey("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := fk.EncryptMessage(ChunkMessage{FileID: "file", ChunkID: 1, Codec: CodecGzip, Data: []byte("data")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := ChunkInfo{Codec: msg.Codec, KeyID: msg.KeyID, WrappedKey: msg.WrappedKey}
+
+	tests := []struct {
+		name    string
+		chunkID int
+		info    ChunkInfo
+	}{
+		{"other chunk", 2, info},
+		{"other codec", 1, ChunkInfo{Codec: CodecNone, KeyID: info.KeyID, WrappedKey: info.WrappedKey}},
+		{"unknown key", 1, ChunkInfo{Codec: info.Codec, KeyID: "k2", WrappedKey: info.WrappedKey}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := kr.DecryptChunk("file", tt.chunkID, tt.info, msg.Data)
+			if !errors.Is(err, ErrDecryptFailed) && !errors.Is(err, ErrUnknownKey) {
+				t.Fatalf("got %v, want a decrypt error", err)
+			}
+		})
+	}
+}
+
+func TestFileHashIsKeyed(t *testing.T) {
+	data := []byte("known capture")
+	plain := sha256.Sum256(data)
+
+	if got := hashHex((*Keyring)(nil).FileHash(), data); got != hex.EncodeToString(plain[:]) {
+		t.Fatalf("nil keyring must use plain SHA-256, got %s", got)
+	}
+
+	kr := testKeyring(t)
+	keyed := hashHex(kr.FileHash(), data)
+	if keyed == hex.EncodeToString(plain[:]) {
+		t.Fatal("keyed file hash equals the plain SHA-256")
+	}
+	if again := hashHex(kr.FileHash(), data); again != keyed {
+		t.Fatalf("keyed file hash is not stable: %s != %s", again, keyed)
+	}
+
+	byID, err := kr.fileHash("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hashHex(byID, data); got != keyed {
+		t.Fatalf("fileHash(k1) = %s, want %s", got, keyed)
+	}
+}
+
+func TestFileKeyChecksum(t *testing.T) {
+	kr := testKeyring(t)
+	fk, err := kr.NewFileKey("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("chunk")
+	sum := fk.Checksum(data)
+	if sum == ChunkChecksum(data) {
+		t.Fatal("encrypted checksum equals the plain SHA-256")
+	}
+	if got := (*FileKey)(nil).Checksum(data); got != ChunkChecksum(data) {
+		t.Fatalf("nil file key must use ChunkChecksum, got %s", got)
+	}
+
+	reopened, err := kr.OpenFileKey("file", fk.KeyID, fk.WrappedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Checksum(data); got != sum {
+		t.Fatalf("reopened key checksum %s, want %s", got, sum)
+	}
+
+	if _, err := kr.OpenFileKey("other", fk.KeyID, fk.WrappedKey); err == nil {
+		t.Fatal("wrapped key opened for another file")
+	}
+}
+
+func TestEncryptedUploadAssembles(t *testing.T) {
+	kr := testKeyring(t)
+	data := bytes.Repeat([]byte("pcap-bytes-"), 500)
+	path := writeTempFile(t, "cap.pcap", data)
+
+	sender := &recordingSender{}
+	opts := UploadOptions{ChunkSize: 1024, Codec: CodecGzip, Keyring: kr}
+	if _, err := UploadFileWithOptions(path, opts, sender); err != nil {
+		t.Fatal(err)
+	}
+
+	fileID := sender.msgs[0].FileID
+	if fileID == GenerateFileID(path) {
+		t.Fatal("encrypted upload uses the plain content hash as FileID")
+	}
+	for _, msg := range sender.msgs {
+		if !msg.IsEOF && msg.RawChecksum == ChunkChecksum(data[msg.ChunkID*1024:min(len(data), (msg.ChunkID+1)*1024)]) {
+			t.Fatalf("chunk %d sends the plain raw checksum", msg.ChunkID)
+		}
+	}
+
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	m.Keyring = kr
+
+	var assembled string
+	for _, msg := range sender.msgs {
+		out, err := m.OnMessage(msg)
+		if err != nil {
+			t.Fatalf("chunk %d: %v", msg.ChunkID, err)
+		}
+		if out != "" {
+			assembled = out
+		}
+	}
+	if !strings.HasPrefix(assembled, "up") {
+		t.Fatalf("file not assembled, got %q", assembled)
+	}
+
+	got, err := m.FS.ReadFile(assembled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("assembled file differs from the original")
+	}
+}
+
+func hashHex(h hash.Hash, data []byte) string {
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
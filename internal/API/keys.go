@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+========================
+KEYS CLI
+========================
+*/
+
+// الأوامر تستدعي مسارات /admin/keys في سيرفر الـ API،
+// لذلك يجب أن يكون LM_API_KEY مفتاح admin (أو LM_ADMIN_KEY الخاص بالسيرفر)
+
+// keyInfo كما يرجعها السيرفر (بدون hash)
+type keyInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func (k keyInfo) state() string {
+	switch {
+	case !k.RevokedAt.IsZero():
+		return "revoked " + k.RevokedAt.Local().Format(time.RFC3339)
+	case k.ExpiresAt.IsZero():
+		return "active"
+	case time.Now().After(k.ExpiresAt):
+		return "expired " + k.ExpiresAt.Local().Format(time.RFC3339)
+	}
+	return "expires " + k.ExpiresAt.Local().Format(time.RFC3339)
+}
+
+// runKeys ينفذ: lm keys list | create <name> | revoke <id> | expire <id> <ttl|never>
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("missing keys command")
+	}
+
+	switch args[0] {
+	case "list":
+		var resp struct {
+			Keys []keyInfo `json:"keys"`
+		}
+		if err := adminRequest("GET", "/admin/keys", nil, &resp); err != nil {
+			return err
+		}
+		for _, k := range resp.Keys {
+			role := "user"
+			if k.Admin {
+				role = "admin"
+			}
+			fmt.Printf("%s  %-20s  %-5s  %s\n", k.ID, k.Name, role, k.state())
+		}
+		return nil
+
+	case "create":
+		flags := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		admin := flags.Bool("admin", false, "allow managing keys")
+		ttl := flags.Duration("ttl", 0, "expire after this duration (0 = never)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			printUsage()
+			return errors.New("missing key name")
+		}
+
+		body := map[string]any{"name": flags.Arg(0), "admin": *admin}
+		if *ttl > 0 {
+			body["ttl"] = ttl.String()
+		}
+
+		var resp struct {
+			Key  string  `json:"key"`
+			Info keyInfo `json:"info"`
+		}
+		if err := adminRequest("POST", "/admin/keys", body, &resp); err != nil {
+			return err
+		}
+		fmt.Println("Created:", resp.Info.ID, "("+resp.Info.state()+")")
+		fmt.Println("Key (shown once):", resp.Key)
+		return nil
+
+	case "revoke":
+		if len(args) != 2 {
+			printUsage()
+			return errors.New("missing key id")
+		}
+		var info keyInfo
+		if err := adminRequest("DELETE", "/admin/keys/"+url.PathEscape(args[1]), nil, &info); err != nil {
+			return err
+		}
+		fmt.Println("Revoked:", info.ID)
+		return nil
+
+	case "expire":
+		if len(args) != 3 {
+			printUsage()
+			return errors.New("usage: lm keys expire <id> <ttl|never>")
+		}
+		ttl := args[2]
+		if ttl == "never" {
+			ttl = ""
+		} else if _, err := time.ParseDuration(ttl); err != nil {
+			return fmt.Errorf("invalid ttl %q", ttl)
+		}
+		var info keyInfo
+		if err := adminRequest("PATCH", "/admin/keys/"+url.PathEscape(args[1]), map[string]any{"ttl": ttl}, &info); err != nil {
+			return err
+		}
+		fmt.Println("Updated:", info.ID, "("+info.state()+")")
+		return nil
+	}
+
+	printUsage()
+	return fmt.Errorf("unknown keys command %q", args[0])
+}
+
+// adminRequest يرسل طلب JSON إلى مسار إدارة في نفس سيرفر LM_API_URL
+func adminRequest(method string, path string, body any, out any) error {
+	base, err := apiBaseURL()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", getAPIKey())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	result, err := readResponse(resp)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &UploadStatusError{StatusCode: resp.StatusCode, Body: result}
+	}
+
+	return json.Unmarshal([]byte(result), out)
+}
+
+// apiBaseURL يأخذ scheme://host من LM_API_URL (مثلاً بدون /split-pcap)
+func apiBaseURL() (string, error) {
+	raw := getAPIURL()
+	if raw == "" {
+		return "", errNoAPIURL
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid LM_API_URL: %q", raw)
+	}
+
+	return strings.TrimSuffix(u.Scheme+"://"+u.Host, "/"), nil
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+========================
+PROGRESS BAR
+========================
+*/
+
+const (
+	progressWidth    = 30
+	progressInterval = 200 * time.Millisecond
+)
+
+// progressReader يعرض شريط تقدم على stderr أثناء قراءة الملف
+type progressReader struct {
+	r     io.Reader
+	total int64
+	read  int64
+	start time.Time
+	last  time.Time
+}
+
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	now := time.Now()
+	return &progressReader{r: r, total: total, start: now}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	// لا نعيد الرسم في كل قراءة حتى لا يُبطئ الطرفية
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.draw(now)
+	}
+
+	return n, err
+}
+
+// Finish يرسم الحالة النهائية وينهي السطر
+func (p *progressReader) Finish() {
+	p.draw(time.Now())
+	fmt.Fprintln(os.Stderr)
+}
+
+func (p *progressReader) draw(now time.Time) {
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(p.read) * 100 / float64(p.total)
+	}
+
+	filled := int(percent / 100 * progressWidth)
+	filled = max(0, min(filled, progressWidth))
+
+	rate := 0.0
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.read) / elapsed
+	}
+
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %5.1f%% %s/%s %s/s ",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressWidth-filled),
+		percent,
+		formatBytes(p.read),
+		formatBytes(p.total),
+		formatBytes(int64(rate)),
+	)
+}
+
+// formatBytes يعرض الحجم بوحدة مناسبة
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
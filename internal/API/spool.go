@@ -0,0 +1,514 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+========================
+SPOOL CONFIG
+========================
+*/
+
+const (
+	// أول انتظار بعد الفشل، ثم يتضاعف مع كل محاولة
+	spoolBaseBackoff = 30 * time.Second
+
+	// أقصى انتظار بين محاولتين
+	spoolMaxBackoff = 1 * time.Hour
+
+	// كل كم يفحص Run الـ spool عندما يكون فارغاً
+	spoolPollInterval = 1 * time.Minute
+)
+
+// getSpoolDir يرجع مجلد الـ spool (LM_SPOOL_DIR أو ~/.lm/spool)
+func getSpoolDir() string {
+	if dir := os.Getenv("LM_SPOOL_DIR"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".lm", "spool")
+	}
+	return ".lm-spool"
+}
+
+/*
+========================
+ERRORS
+========================
+*/
+
+var errNoAPIURL = errors.New("API URL is not configured (LM_API_URL)")
+
+// errSpoolChanged نسخة الـ spool لم تعد كما حُفظت (لا تُرسل)
+var errSpoolChanged = errors.New("spooled copy changed")
+
+// UploadStatusError رد غير ناجح من الـ API
+type UploadStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UploadStatusError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// isRetryable يحدد هل يستحق الرفع إعادة المحاولة لاحقاً
+// أخطاء الشبكة و 5xx و 429 مؤقتة، باقي 4xx تحتاج تدخل المستخدم
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, errNoAPIURL) {
+		return false
+	}
+
+	var statusErr *UploadStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	// فشل الاتصال نفسه (الـ API أو الشبكة غير متاحة)
+	return true
+}
+
+/*
+========================
+SPOOL ENTRIES
+========================
+*/
+
+// SpoolEntry رفع فاشل ينتظر إعادة المحاولة
+type SpoolEntry struct {
+	ID          string    `json:"id"`
+	FileName    string    `json:"file_name"`
+	Source      string    `json:"source"` // المسار الأصلي وقت الفشل
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"` // للتحقق من النسخة قبل كل محاولة
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	Permanent   bool      `json:"permanent"` // خطأ لا يُعاد تلقائياً
+	CreatedAt   time.Time `json:"created_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// Spool مجلد يحفظ نسخة من كل ملف فشل رفعه مع بياناته
+//
+// لكل ملف: <id>.pcap (البيانات) و <id>.json (الـ SpoolEntry)
+type Spool struct {
+	Dir string
+}
+
+// NewSpool ينشئ Spool على المجلد المحدد
+func NewSpool(dir string) *Spool {
+	return &Spool{Dir: dir}
+}
+
+func (s *Spool) dataPath(id string) string {
+	return filepath.Join(s.Dir, id+".pcap")
+}
+
+func (s *Spool) metaPath(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// Add يضع ملفاً في الـ spool بعد فشل رفعه
+//
+// البيانات تُنسخ دائماً حتى لا يضيع الملف إذا حذفه tcpdump أثناء التدوير
+// NOTE: لا يُستخدم hard link: نفس الـ inode، فإعادة كتابة الملف بـ O_TRUNC
+// تغيّر النسخة المحفوظة أيضاً. الحجم و SHA-256 يُحفظان ويُتحقق منهما قبل كل محاولة
+func (s *Spool) Add(filePath string, cause error) (*SpoolEntry, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	id, err := newSpoolID()
+	if err != nil {
+		return nil, err
+	}
+
+	size, sum, err := copyFile(filePath, s.dataPath(id))
+	if err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	entry := &SpoolEntry{
+		ID:          id,
+		FileName:    filepath.Base(filePath),
+		Source:      filePath,
+		Size:        size,
+		SHA256:      sum,
+		Attempts:    1,
+		LastError:   cause.Error(),
+		CreatedAt:   now,
+		NextAttempt: now.Add(backoff(1)),
+	}
+
+	if err := s.save(entry); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// List يرجع كل العناصر مرتبة حسب وقت الإضافة
+func (s *Spool) List() ([]*SpoolEntry, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*SpoolEntry
+	for _, m := range matches {
+		entry, err := s.Get(strings.TrimSuffix(filepath.Base(m), ".json"))
+		if err != nil {
+			fmt.Println("Warning: skipping spool entry:", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries, nil
+}
+
+// Get يقرأ عنصراً واحداً
+func (s *Spool) Get(id string) (*SpoolEntry, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid spool id %q", id)
+	}
+
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("spool entry %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry SpoolEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("corrupted spool entry %s: %w", id, err)
+	}
+
+	return &entry, nil
+}
+
+// Drop يحذف عنصراً مع بياناته
+func (s *Spool) Drop(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return os.Remove(s.metaPath(id))
+}
+
+// Retry يحاول رفع عنصر واحد الآن
+//
+// النجاح يحذف العنصر، الفشل يزيد عدد المحاولات ويحدد الموعد التالي
+// نسخة تغيّرت منذ الحفظ لا تُرسل وتبقى حتى drop <id>
+func (s *Spool) Retry(entry *SpoolEntry) error {
+	err := s.verify(entry)
+	if err == nil {
+		err = postFile(s.dataPath(entry.ID), entry.FileName)
+	}
+	if err == nil {
+		return s.Drop(entry.ID)
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	entry.Permanent = errors.Is(err, errSpoolChanged) || !isRetryable(err)
+	entry.NextAttempt = time.Now().UTC().Add(backoff(entry.Attempts))
+
+	if saveErr := s.save(entry); saveErr != nil {
+		return saveErr
+	}
+
+	return err
+}
+
+// verify يتأكد أن نسخة الـ spool ما زالت كما حُفظت
+// NOTE: العناصر القديمة بدون SHA256 يُتحقق من حجمها فقط
+func (s *Spool) verify(entry *SpoolEntry) error {
+	if entry.SHA256 == "" {
+		info, err := os.Stat(s.dataPath(entry.ID))
+		if err != nil {
+			return err
+		}
+		if info.Size() != entry.Size {
+			return fmt.Errorf("%w: size %d, want %d", errSpoolChanged, info.Size(), entry.Size)
+		}
+		return nil
+	}
+
+	size, sum, err := hashFile(s.dataPath(entry.ID))
+	if err != nil {
+		return err
+	}
+	if size != entry.Size || sum != entry.SHA256 {
+		return fmt.Errorf("%w: %d bytes sha256 %s, want %d bytes sha256 %s", errSpoolChanged, size, sum, entry.Size, entry.SHA256)
+	}
+	return nil
+}
+
+// RetryDue يحاول مرة واحدة كل عنصر حان موعده
+//
+// يرجع عدد العناصر المتبقية (بدون الدائمة) وموعد أقرب محاولة تالية
+func (s *Spool) RetryDue() (int, time.Time, error) {
+	entries, err := s.List()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var next time.Time
+	pending := 0
+
+	for _, entry := range entries {
+		if entry.Permanent {
+			continue
+		}
+		pending++
+
+		if time.Until(entry.NextAttempt) <= 0 {
+			fmt.Printf("Retrying %s (%s), attempt %d\n", entry.ID, entry.FileName, entry.Attempts+1)
+			err := s.Retry(entry)
+			if err == nil {
+				pending--
+				fmt.Println("Delivered:", entry.FileName)
+				continue
+			}
+			fmt.Println("Failed:", err)
+
+			// خطأ دائم: ينتظر retry <id> أو drop <id>
+			if entry.Permanent {
+				pending--
+				continue
+			}
+		}
+
+		if next.IsZero() || entry.NextAttempt.Before(next) {
+			next = entry.NextAttempt
+		}
+	}
+
+	return pending, next, nil
+}
+
+// RetryLoop يعيد المحاولة حتى يفرغ الـ spool
+//
+// ينتظر موعد كل عنصر (exponential backoff)، والعناصر ذات
+// الأخطاء الدائمة تبقى حتى retry <id> أو drop <id>
+func (s *Spool) RetryLoop() error {
+	for {
+		pending, next, err := s.RetryDue()
+		if err != nil {
+			return err
+		}
+
+		if pending == 0 {
+			return nil
+		}
+
+		fmt.Printf("%d pending, next attempt at %s\n", pending, next.Local().Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+	}
+}
+
+// Run يعيد المحاولة في الخلفية حتى يُلغى ctx
+//
+// مثل RetryLoop لكنه لا يتوقف عندما يفرغ الـ spool: يفحص كل
+// spoolPollInterval حتى تصل العناصر الجديدة من lm upload
+func (s *Spool) Run(ctx context.Context) {
+	for {
+		wait := spoolPollInterval
+
+		pending, next, err := s.RetryDue()
+		if err != nil {
+			fmt.Println("Warning: spool retry failed:", err)
+		} else if pending > 0 {
+			wait = min(max(time.Until(next), 0), spoolPollInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Spool) save(entry *SpoolEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.metaPath(entry.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.metaPath(entry.ID))
+}
+
+/*
+========================
+SPOOL HELPERS
+========================
+*/
+
+// backoff يرجع الانتظار بعد عدد المحاولات: 30s, 1m, 2m, ... حتى 1h
+func backoff(attempts int) time.Duration {
+	d := spoolBaseBackoff
+	for i := 1; i < attempts && d < spoolMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, spoolMaxBackoff)
+}
+
+func newSpoolID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", time.Now().Unix(), hex.EncodeToString(b)), nil
+}
+
+// copyFile ينسخ الملف ويرجع حجمه و SHA-256 للنسخة
+func copyFile(src string, dst string) (int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		out.Close()
+		return 0, "", err
+	}
+
+	if err := out.Close(); err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashFile يرجع حجم الملف و SHA-256
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+/*
+========================
+SPOOL CLI
+========================
+*/
+
+// runSpool ينفذ: lm spool list | retry [id] | run | drop <id>
+func runSpool(args []string) error {
+	spool := NewSpool(getSpoolDir())
+
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("missing spool command")
+	}
+
+	switch args[0] {
+	case "list":
+		entries, err := spool.List()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("Spool is empty:", spool.Dir)
+			return nil
+		}
+		for _, e := range entries {
+			next := e.NextAttempt.Local().Format(time.RFC3339)
+			if e.Permanent {
+				next = "manual"
+			}
+			fmt.Printf("%s  %s  %d bytes  attempts=%d  next=%s\n    last error: %s\n",
+				e.ID, e.FileName, e.Size, e.Attempts, next, e.LastError)
+		}
+		return nil
+
+	case "retry":
+		if len(args) == 1 {
+			return spool.RetryLoop()
+		}
+		entry, err := spool.Get(args[1])
+		if err != nil {
+			return err
+		}
+		if err := spool.Retry(entry); err != nil {
+			return err
+		}
+		fmt.Println("Delivered:", entry.FileName)
+		return nil
+
+	case "run":
+		// يعمل في الخلفية (مثلاً كخدمة systemd) حتى Ctrl+C
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		fmt.Println("Retrying spooled uploads in the background:", spool.Dir)
+		spool.Run(ctx)
+		return nil
+
+	case "drop":
+		if len(args) != 2 {
+			printUsage()
+			return errors.New("missing spool id")
+		}
+		if err := spool.Drop(args[1]); err != nil {
+			return err
+		}
+		fmt.Println("Dropped:", args[1])
+		return nil
+	}
+
+	printUsage()
+	return fmt.Errorf("unknown spool command %q", args[0])
+}
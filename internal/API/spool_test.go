@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpoolAddCopiesFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "rotated.pcap")
+	if err := os.WriteFile(src, []byte("original capture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spool := NewSpool(t.TempDir())
+	entry, err := spool.Add(src, errors.New("connection refused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tcpdump يعيد استخدام الاسم (O_TRUNC): النسخة لا تتغير
+	if err := os.WriteFile(src, []byte("next"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(spool.dataPath(entry.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "original capture" {
+		t.Fatalf("spooled copy changed with the source: %q", data)
+	}
+	if entry.Size != int64(len(data)) || entry.SHA256 == "" {
+		t.Fatalf("size/hash not recorded: %+v", entry)
+	}
+	if err := spool.verify(entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolRetryRejectsChangedCopy(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "cap.pcap")
+	if err := os.WriteFile(src, []byte("capture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spool := NewSpool(t.TempDir())
+	entry, err := spool.Add(src, errors.New("timeout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(spool.dataPath(entry.ID), []byte("CAPTURE"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spool.Retry(entry); !errors.Is(err, errSpoolChanged) {
+		t.Fatalf("got %v, want errSpoolChanged", err)
+	}
+
+	saved, err := spool.Get(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Permanent || saved.Attempts != 2 {
+		t.Fatalf("changed copy must wait for the user, got %+v", saved)
+	}
+}
+
+func TestSpoolRetryDueSkipsFutureEntries(t *testing.T) {
+	t.Setenv("LM_API_URL", "")
+
+	src := filepath.Join(t.TempDir(), "cap.pcap")
+	if err := os.WriteFile(src, []byte("capture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spool := NewSpool(t.TempDir())
+	entry, err := spool.Add(src, errors.New("timeout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, next, err := spool.RetryDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 || !next.Equal(entry.NextAttempt) {
+		t.Fatalf("got pending=%d next=%s, want 1 and %s", pending, next, entry.NextAttempt)
+	}
+
+	// حان الموعد: بدون LM_API_URL الخطأ دائم فلا يبقى شيء للمحاولة التلقائية
+	entry.NextAttempt = time.Now().Add(-time.Second)
+	if err := spool.save(entry); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _, err = spool.RetryDue(); err != nil || pending != 0 {
+		t.Fatalf("got pending=%d err=%v, want 0", pending, err)
+	}
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Fatalf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
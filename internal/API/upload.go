@@ -1,6 +1,7 @@
 package api
 
 import (
+	"LM-Gate/internal/infra"
 	"bytes"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 /*
@@ -24,6 +26,21 @@ func getAPIKey() string {
 	return os.Getenv("LM_API_KEY")
 }
 
+// getUploadRate يرجع حد سرعة الرفع بالبايت/ثانية (LM_UPLOAD_RATE)، 0 = بدون حد
+func getUploadRate() (int64, error) {
+	v := os.Getenv("LM_UPLOAD_RATE")
+	if v == "" {
+		return 0, nil
+	}
+
+	rate, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid LM_UPLOAD_RATE: %q", v)
+	}
+
+	return rate, nil
+}
+
 /*
 ========================
 CLI HELP
@@ -33,6 +50,14 @@ CLI HELP
 func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  lm upload <file.pcap>")
+	fmt.Println("  lm spool list")
+	fmt.Println("  lm spool retry [id]")
+	fmt.Println("  lm spool run")
+	fmt.Println("  lm spool drop <id>")
+	fmt.Println("  lm keys list")
+	fmt.Println("  lm keys create [--admin] [--ttl 720h] <name>")
+	fmt.Println("  lm keys revoke <id>")
+	fmt.Println("  lm keys expire <id> <ttl|never>")
 }
 
 /*
@@ -41,32 +66,73 @@ UPLOAD FLOW
 ========================
 */
 
+// uploadFile يرفع الملف، وعند فشل مؤقت يضعه في الـ spool
 func uploadFile(filePath string) error {
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	spool := NewSpool(getSpoolDir())
+
+	err := postFile(filePath, filepath.Base(filePath))
+	if err == nil {
+		// الـ API متاح الآن: إرسال ما حان موعده من الـ spool
+		if _, _, err := spool.RetryDue(); err != nil {
+			fmt.Println("Warning: spool retry failed:", err)
+		}
+		return nil
+	}
+	if !isRetryable(err) {
+		return err
+	}
+
+	// الـ API أو الشبكة غير متاحة: الملف لا يضيع
+	entry, spoolErr := spool.Add(filePath, err)
+	if spoolErr != nil {
+		return fmt.Errorf("%w (spool failed: %v)", err, spoolErr)
+	}
+
+	fmt.Println("Upload failed, queued for retry:", entry.ID)
+	fmt.Println("It is retried after the next successful upload, or run 'lm spool run' to retry in the background.")
+	return nil
+}
+
+// postFile يرسل الملف إلى الـ API ويرجع خطأ عند أي رد غير 2xx
+func postFile(filePath string, fileName string) error {
 	file, err := openFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	body, contentType, err := buildMultipartBody(
+	body, contentType, contentLength, err := buildMultipartBody(
 		"pcapfile",
 		file,
-		filepath.Base(filePath),
+		fileName,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to build multipart body: %w", err)
 	}
+	defer body.Close()
 
 	apiURL := getAPIURL()
 	apiKey := getAPIKey()
 
 	if apiURL == "" {
-		return fmt.Errorf("API URL is not configured (LM_API_URL)")
+		return errNoAPIURL
+	}
+
+	rate, err := getUploadRate()
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("Using API:", apiURL)
 
-	req, err := createUploadRequest(apiURL, body, contentType, apiKey)
+	// تحديد السرعة حتى لا يُشبع الرفع خط الاتصال المشترك
+	limited := infra.NewRateLimitedReader(body, infra.NewRateLimiter(rate))
+
+	req, err := createUploadRequest(apiURL, limited, contentLength, contentType, apiKey)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -81,61 +147,15 @@ func uploadFile(filePath string) error {
 		return fmt.Errorf("failed to read response: %w", err)
 	}
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &UploadStatusError{StatusCode: resp.StatusCode, Body: result}
+	}
+
 	fmt.Println("Server response:")
 	fmt.Println(result)
 	return nil
 }
 
-func saveUploadedFile(file multipart.File, filename string, baseDir string) (string, error) {
-
-	// تأكد من وجود المجلد
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		return "", err
-	}
-
-	// حماية من path traversal
-	safeName := filepath.Base(filename)
-	dstPath := filepath.Join(baseDir, safeName)
-
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		return "", err
-	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, file); err != nil {
-		return "", err
-	}
-
-	return dstPath, nil
-}
-
-func UploadHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(100 << 20); err != nil {
-		http.Error(w, "invalid form", 400)
-		return
-	}
-
-	file, header, err := r.FormFile("pcapfile")
-	if err != nil {
-		http.Error(w, "file missing", 400)
-		return
-	}
-	defer file.Close()
-
-	path, err := saveUploadedFile(
-		file,
-		header.Filename,
-		"/data/uploads",
-	)
-	if err != nil {
-		http.Error(w, "save failed", 500)
-		return
-	}
-
-	fmt.Fprintf(w, "File saved: %s", path)
-}
-
 /*
 
 ========================
@@ -148,36 +168,63 @@ func openFile(path string) (*os.File, error) {
 	return os.Open(path)
 }
 
-// 2️⃣ بناء Multipart Body
-func buildMultipartBody(fieldName string, file *os.File, fileName string) (*bytes.Buffer, string, error) {
+// 2️⃣ بناء Multipart Body كـ stream (io.Pipe) بدون تحميل الملف في الذاكرة
+// يرجع أيضاً الطول الكامل للـ body حتى يُرسل Content-Length
+// NOTE: يجب إغلاق الـ body دائماً حتى يتوقف الـ goroutine الكاتب
+func buildMultipartBody(fieldName string, file *os.File, fileName string) (io.ReadCloser, string, int64, error) {
 
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
-
-	part, err := writer.CreateFormFile(fieldName, fileName)
+	info, err := file.Stat()
 	if err != nil {
-		return nil, "", err
+		return nil, "", 0, err
 	}
 
-	if _, err := io.Copy(part, file); err != nil {
-		return nil, "", err
-	}
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
 
-	if err := writer.Close(); err != nil {
-		return nil, "", err
+	// حساب حجم الـ headers والـ boundary بنفس الـ boundary
+	var overhead bytes.Buffer
+	measure := multipart.NewWriter(&overhead)
+	if err := measure.SetBoundary(writer.Boundary()); err != nil {
+		return nil, "", 0, err
 	}
+	if _, err := measure.CreateFormFile(fieldName, fileName); err != nil {
+		return nil, "", 0, err
+	}
+	if err := measure.Close(); err != nil {
+		return nil, "", 0, err
+	}
+
+	source := newProgressReader(file, info.Size())
+
+	go func() {
+		part, err := writer.CreateFormFile(fieldName, fileName)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
 
-	return &body, writer.FormDataContentType(), nil
+		if _, err := io.Copy(part, source); err != nil {
+			fmt.Fprintln(os.Stderr)
+			pw.CloseWithError(err)
+			return
+		}
+
+		source.Finish()
+		pw.CloseWithError(writer.Close())
+	}()
+
+	return pr, writer.FormDataContentType(), int64(overhead.Len()) + info.Size(), nil
 }
 
 // 3️⃣ إنشاء HTTP Request
-func createUploadRequest(url string, body *bytes.Buffer, contentType string, apiKey string) (*http.Request, error) {
+func createUploadRequest(url string, body io.Reader, contentLength int64, contentType string, apiKey string) (*http.Request, error) {
 
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
 		return nil, err
 	}
 
+	req.ContentLength = contentLength
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("X-API-Key", apiKey)
 
@@ -199,6 +246,22 @@ func readResponse(resp *http.Response) (string, error) {
 }
 
 func RunUploadLogic() {
+	if len(os.Args) >= 2 && os.Args[1] == "spool" {
+		if err := runSpool(os.Args[2:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if len(os.Args) != 3 || os.Args[1] != "upload" {
 		printUsage()
 		os.Exit(1)
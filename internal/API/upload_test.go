@@ -0,0 +1,54 @@
+package api
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildMultipartBodyStreamsFile(t *testing.T) {
+	data := bytes.Repeat([]byte("capture "), 10_000)
+	path := filepath.Join(t.TempDir(), "cap.pcap")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	body, contentType, length, err := buildMultipartBody("pcapfile", file, "cap.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Content-Length محسوب مسبقاً، يجب أن يطابق ما كُتب فعلاً
+	if int64(len(raw)) != length {
+		t.Fatalf("body is %d bytes, declared %d", len(raw), length)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", contentType)
+	part, err := infra.OpenMultipartFile(req, "pcapfile", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(part.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || part.FileName != "cap.pcap" {
+		t.Fatalf("got %d bytes as %q, want %d as cap.pcap", len(got), part.FileName, len(data))
+	}
+}
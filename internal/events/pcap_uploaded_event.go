@@ -1,7 +1,10 @@
 package events
 
 type PcapUploadedEvent struct {
+	JobID    string // فارغ في الأحداث القديمة، الـ worker ينشئ مهمة عندها
 	FileName string
 	Path     string
 	Size     int64
+	Split    string // استراتيجية التقسيم مثل "time:60s"، فارغ = كل 1000 حزمة
+	Output   string // صيغة الأجزاء pcap أو pcapng، فارغ = pcap
 }
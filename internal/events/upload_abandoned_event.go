@@ -0,0 +1,14 @@
+package events
+
+import "time"
+
+// UploadAbandonedEvent يُنشر عندما يحذف الـ Janitor رفعاً لم يكتمل
+// (لم يصل EOF ولم يحدث أي نشاط خلال الـ TTL)
+type UploadAbandonedEvent struct {
+	FileID       string
+	Uploader     string
+	Received     int // عدد القطع المستلمة (0 للرفع عبر HTTP)
+	Total        int // 0 إذا كان غير معروف
+	LastActivity time.Time
+	Stores       []string // "disk" و/أو "redis"، أو "resumable" للرفع القابل للاستكمال
+}
@@ -0,0 +1,346 @@
+package infra
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyRevoked  = errors.New("API key revoked")
+	ErrAPIKeyExpired  = errors.New("API key expired")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is one stored key. The raw key is never stored,
+// only its SHA-256 hash.
+//
+// NOTE: ID is UploaderFromKey(raw key), so quota usage,
+// jobs and logs all name the same key the same way.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash,omitempty"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // zero = never
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+// Active reports whether the key may be used at now.
+func (k *APIKey) Active(now time.Time) error {
+	if !k.RevokedAt.IsZero() {
+		return ErrAPIKeyRevoked
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+// APIKeyStore persists API keys (file or Redis).
+type APIKeyStore interface {
+	List() ([]APIKey, error)
+	Get(id string) (*APIKey, error)
+	Save(key APIKey) error
+}
+
+// APIKeys creates, checks and revokes API keys.
+//
+// NOTE: AdminKey (LM_ADMIN_KEY) is a bootstrap admin key that is
+// not stored; it is used to create the first real keys.
+type APIKeys struct {
+	Store    APIKeyStore
+	AdminKey string
+}
+
+// NewAPIKeys creates a key manager over store.
+func NewAPIKeys(store APIKeyStore, adminKey string) *APIKeys {
+	return &APIKeys{Store: store, AdminKey: adminKey}
+}
+
+// Create generates a new key and returns it once in raw form.
+// ttl = 0 means the key never expires.
+func (k *APIKeys) Create(name string, admin bool, ttl time.Duration) (string, *APIKey, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	raw := "lm_" + hex.EncodeToString(b)
+
+	key := APIKey{
+		ID:        UploaderFromKey(raw),
+		Name:      name,
+		Hash:      hashAPIKey(raw),
+		Admin:     admin,
+		CreatedAt: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		key.ExpiresAt = key.CreatedAt.Add(ttl)
+	}
+
+	if err := k.Store.Save(key); err != nil {
+		return "", nil, err
+	}
+
+	return raw, &key, nil
+}
+
+// Authenticate returns the key matching raw.
+//
+// NOTE: Every stored hash is compared in constant time and the loop
+// does not stop at the first match, so timing does not leak which
+// key (or how much of it) matched.
+func (k *APIKeys) Authenticate(raw string) (*APIKey, error) {
+	if raw == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if k.AdminKey != "" && subtle.ConstantTimeCompare([]byte(raw), []byte(k.AdminKey)) == 1 {
+		return &APIKey{ID: UploaderFromKey(raw), Name: "LM_ADMIN_KEY", Admin: true}, nil
+	}
+
+	keys, err := k.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := []byte(hashAPIKey(raw))
+	var found *APIKey
+	for i := range keys {
+		if subtle.ConstantTimeCompare(hash, []byte(keys[i].Hash)) == 1 {
+			found = &keys[i]
+		}
+	}
+
+	if found == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if err := found.Active(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// List returns every key without hashes, sorted by creation time.
+func (k *APIKeys) List() ([]APIKey, error) {
+	keys, err := k.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// Revoke disables a key immediately.
+func (k *APIKeys) Revoke(id string) (*APIKey, error) {
+	return k.update(id, func(key *APIKey) {
+		if key.RevokedAt.IsZero() {
+			key.RevokedAt = time.Now().UTC()
+		}
+	})
+}
+
+// Expire sets when a key stops working (zero = never).
+func (k *APIKeys) Expire(id string, at time.Time) (*APIKey, error) {
+	return k.update(id, func(key *APIKey) {
+		key.ExpiresAt = at.UTC()
+	})
+}
+
+func (k *APIKeys) update(id string, change func(*APIKey)) (*APIKey, error) {
+	key, err := k.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	change(key)
+	if err := k.Store.Save(*key); err != nil {
+		return nil, err
+	}
+
+	key.Hash = ""
+	return key, nil
+}
+
+func hashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+========================
+FILE STORE
+========================
+*/
+
+// FileAPIKeyStore keeps keys in a JSON file (temporary file + rename).
+//
+// NOTE: The file is read on every call, so keys created by another
+// process are seen without a restart.
+type FileAPIKeyStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFileAPIKeyStore creates a store backed by path.
+func NewFileAPIKeyStore(path string) *FileAPIKeyStore {
+	return &FileAPIKeyStore{Path: path}
+}
+
+func (s *FileAPIKeyStore) List() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byID, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(byID))
+	for _, key := range byID {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *FileAPIKeyStore) Get(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byID, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := byID[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &key, nil
+}
+
+func (s *FileAPIKeyStore) Save(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byID, err := s.load()
+	if err != nil {
+		return err
+	}
+	byID[key.ID] = key
+
+	data, err := json.MarshalIndent(byID, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+
+	// 0600: the file holds the key hashes
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *FileAPIKeyStore) load() (map[string]APIKey, error) {
+	byID := make(map[string]APIKey)
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return byID, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &byID); err != nil {
+		return nil, fmt.Errorf("corrupted API key store %s: %w", s.Path, err)
+	}
+	return byID, nil
+}
+
+/*
+========================
+REDIS STORE
+========================
+*/
+
+// apiKeysKey hash: key ID → APIKey (JSON)
+const apiKeysKey = "apikeys"
+
+// RedisAPIKeyStore keeps keys in a Redis hash.
+type RedisAPIKeyStore struct {
+	redis *RedisService
+}
+
+// NewRedisAPIKeyStore creates a store on an existing Redis connection.
+func NewRedisAPIKeyStore(r *RedisService) *RedisAPIKeyStore {
+	return &RedisAPIKeyStore{redis: r}
+}
+
+func (s *RedisAPIKeyStore) List() ([]APIKey, error) {
+	entries, err := s.redis.client.HGetAll(ctx, apiKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(entries))
+	for id, data := range entries {
+		var key APIKey
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			return nil, fmt.Errorf("corrupted API key %s: %w", id, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *RedisAPIKeyStore) Get(id string) (*APIKey, error) {
+	data, err := s.redis.client.HGet(ctx, apiKeysKey, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, fmt.Errorf("corrupted API key %s: %w", id, err)
+	}
+	return &key, nil
+}
+
+func (s *RedisAPIKeyStore) Save(key APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.redis.client.HSet(ctx, apiKeysKey, key.ID, data).Err()
+}
@@ -0,0 +1,244 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Capture error codes returned to API clients.
+const (
+	CodeEmptyUpload            = "empty_upload"
+	CodeTruncatedHeader        = "truncated_header"
+	CodeUnsupportedFormat      = "unsupported_format"
+	CodeUnsupportedCompression = "unsupported_compression"
+	CodeUploadTooLarge         = "upload_too_large"
+)
+
+// ErrInvalidCapture is matched by every CaptureError.
+var ErrInvalidCapture = errors.New("invalid capture")
+
+// CaptureError explains why a capture was rejected.
+type CaptureError struct {
+	Code    string
+	Message string
+}
+
+func (e *CaptureError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap allows errors.Is(err, ErrInvalidCapture).
+func (e *CaptureError) Unwrap() error {
+	return ErrInvalidCapture
+}
+
+// CaptureErrorStatus maps a capture or size error to an HTTP status, or 0.
+func CaptureErrorStatus(err error) int {
+	switch CaptureErrorCode(err) {
+	case CodeEmptyUpload, CodeTruncatedHeader:
+		return http.StatusBadRequest
+	case CodeUnsupportedFormat, CodeUnsupportedCompression:
+		return http.StatusUnsupportedMediaType
+	case CodeUploadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	}
+	return 0
+}
+
+// CaptureErrorCode returns the code of a capture or size error, or "".
+func CaptureErrorCode(err error) string {
+	var captureErr *CaptureError
+	if errors.As(err, &captureErr) {
+		return captureErr.Code
+	}
+	if errors.Is(err, ErrUploadTooLarge) {
+		return CodeUploadTooLarge
+	}
+	return ""
+}
+
+// Capture containers and compressions.
+const (
+	FormatPcap   = "pcap"
+	FormatPcapNG = "pcapng"
+
+	CompressionGzip  = "gzip"
+	CompressionBzip2 = "bzip2"
+	CompressionZstd  = "zstd"
+	CompressionXZ    = "xz"
+)
+
+// CaptureFormat is what the first bytes of a capture say about it.
+type CaptureFormat struct {
+	Container   string `json:"container"`             // pcap or pcapng
+	ByteOrder   string `json:"byte_order"`            // little or big
+	Precision   string `json:"precision,omitempty"`   // micro or nano (pcap only)
+	Compression string `json:"compression,omitempty"` // empty when not compressed
+}
+
+func (f CaptureFormat) String() string {
+	parts := []string{f.Container}
+	if f.Compression != "" {
+		parts[0] = f.Compression + "+" + f.Container
+	}
+	parts = append(parts, f.ByteOrder+"-endian")
+	if f.Precision != "" {
+		parts = append(parts, f.Precision+"seconds")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SniffSize is how many bytes are peeked (enough to look
+// inside the first block of a compressed capture).
+const SniffSize = 64 * 1024
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicXZ    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// SniffCapture detects the capture format from the first bytes of r
+// without consuming them: the returned reader yields the whole stream.
+func SniffCapture(r io.Reader) (CaptureFormat, io.Reader, error) {
+	br := bufio.NewReaderSize(r, SniffSize)
+
+	head, err := br.Peek(SniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return CaptureFormat{}, br, err
+	}
+
+	format, err := DetectCaptureFormat(head)
+	return format, br, err
+}
+
+// DetectCaptureFormat detects the format from the start of a capture.
+// For compressed captures, head must hold enough compressed bytes
+// to decode the inner header.
+func DetectCaptureFormat(head []byte) (CaptureFormat, error) {
+	if len(head) == 0 {
+		return CaptureFormat{}, &CaptureError{Code: CodeEmptyUpload, Message: "the upload is empty"}
+	}
+
+	var compression string
+	var inner io.Reader
+
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		zr, err := gzip.NewReader(bytes.NewReader(head))
+		if err != nil {
+			return CaptureFormat{}, &CaptureError{Code: CodeTruncatedHeader, Message: "corrupted gzip header"}
+		}
+		compression, inner = CompressionGzip, zr
+	case bytes.HasPrefix(head, magicBzip2):
+		compression, inner = CompressionBzip2, bzip2.NewReader(bytes.NewReader(head))
+	case bytes.HasPrefix(head, magicZstd):
+		return CaptureFormat{Compression: CompressionZstd}, &CaptureError{Code: CodeUnsupportedCompression, Message: "zstd captures are not supported, use gzip or bzip2"}
+	case bytes.HasPrefix(head, magicXZ):
+		return CaptureFormat{Compression: CompressionXZ}, &CaptureError{Code: CodeUnsupportedCompression, Message: "xz captures are not supported, use gzip or bzip2"}
+	}
+
+	if inner != nil {
+		// head may end inside the compressed block, use what was decoded
+		decoded := make([]byte, 32)
+		n, _ := io.ReadFull(inner, decoded)
+		head = decoded[:n]
+	}
+
+	format, err := detectContainer(head)
+	format.Compression = compression
+	return format, err
+}
+
+// detectContainer reads the pcap or pcapng magic.
+func detectContainer(head []byte) (CaptureFormat, error) {
+	if len(head) < 4 {
+		return CaptureFormat{}, &CaptureError{Code: CodeTruncatedHeader, Message: "capture header is shorter than 4 bytes"}
+	}
+
+	switch binary.BigEndian.Uint32(head) {
+	case 0xa1b2c3d4:
+		return pcapFormat(head, "big", "micro")
+	case 0xd4c3b2a1:
+		return pcapFormat(head, "little", "micro")
+	case 0xa1b23c4d:
+		return pcapFormat(head, "big", "nano")
+	case 0x4d3cb2a1:
+		return pcapFormat(head, "little", "nano")
+	case 0x0a0d0d0a:
+		// Section Header Block: block type, block length, byte-order magic
+		if len(head) < 12 {
+			return CaptureFormat{}, &CaptureError{Code: CodeTruncatedHeader, Message: "pcapng section header is truncated"}
+		}
+		switch binary.BigEndian.Uint32(head[8:]) {
+		case 0x1a2b3c4d:
+			return CaptureFormat{Container: FormatPcapNG, ByteOrder: "big"}, nil
+		case 0x4d3c2b1a:
+			return CaptureFormat{Container: FormatPcapNG, ByteOrder: "little"}, nil
+		}
+		return CaptureFormat{}, &CaptureError{Code: CodeUnsupportedFormat, Message: "invalid pcapng byte-order magic"}
+	}
+
+	return CaptureFormat{}, &CaptureError{Code: CodeUnsupportedFormat, Message: "not a pcap or pcapng capture"}
+}
+
+// pcapHeaderLen is the size of the classic pcap global header.
+const pcapHeaderLen = 24
+
+func pcapFormat(head []byte, order string, precision string) (CaptureFormat, error) {
+	format := CaptureFormat{Container: FormatPcap, ByteOrder: order, Precision: precision}
+	if len(head) < pcapHeaderLen {
+		return format, &CaptureError{Code: CodeTruncatedHeader, Message: "pcap global header is shorter than 24 bytes"}
+	}
+	return format, nil
+}
+
+// DecompressCapture wraps r with the decoder for the sniffed compression.
+//
+// NOTE: limit caps the decompressed size (0 = no limit), so a small
+// compressed upload cannot expand without bound. Reading past it returns
+// ErrUploadTooLarge. Uncompressed captures are returned as they are,
+// their size is limited when they are received.
+func DecompressCapture(r io.Reader, format CaptureFormat, limit int64) (io.Reader, error) {
+	var decoded io.Reader
+	switch format.Compression {
+	case "":
+		return r, nil
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		decoded = gz
+	case CompressionBzip2:
+		decoded = bzip2.NewReader(r)
+	default:
+		return nil, &CaptureError{Code: CodeUnsupportedCompression, Message: format.Compression + " captures are not supported"}
+	}
+
+	if limit > 0 {
+		decoded = &sizeLimitedReader{r: decoded, remaining: limit}
+	}
+	return decoded, nil
+}
+
+// OpenCapture sniffs r and returns the decompressed capture stream
+// (at most limit bytes, see DecompressCapture).
+func OpenCapture(r io.Reader, limit int64) (CaptureFormat, io.Reader, error) {
+	format, r, err := SniffCapture(r)
+	if err != nil {
+		return format, nil, err
+	}
+
+	r, err = DecompressCapture(r, format, limit)
+	return format, r, err
+}
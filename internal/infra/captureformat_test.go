@@ -0,0 +1,67 @@
+package infra
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressCaptureLimit(t *testing.T) {
+	// ملف صغير مضغوط يتمدد إلى 1MB
+	plain := make([]byte, 1<<20)
+	compressed := gzipBytes(t, plain)
+	gz := CaptureFormat{Container: FormatPcap, Compression: CompressionGzip}
+
+	tests := []struct {
+		name    string
+		in      []byte
+		format  CaptureFormat
+		limit   int64
+		wantErr error
+		wantLen int
+	}{
+		{"under limit", compressed, gz, int64(len(plain)), nil, len(plain)},
+		{"no limit", compressed, gz, 0, nil, len(plain)},
+		{"over limit", compressed, gz, 1024, ErrUploadTooLarge, 1024},
+		{"uncompressed ignores limit", plain, CaptureFormat{Container: FormatPcap}, 1024, nil, len(plain)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := DecompressCapture(bytes.NewReader(tt.in), tt.format, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != tt.wantLen {
+				t.Fatalf("read %d bytes, want %d", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestDecompressCaptureUnsupported(t *testing.T) {
+	_, err := DecompressCapture(bytes.NewReader(nil), CaptureFormat{Compression: CompressionZstd}, 0)
+	if CaptureErrorCode(err) != CodeUnsupportedCompression {
+		t.Fatalf("got %v, want %s", err, CodeUnsupportedCompression)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package infra
+
+import "sync"
+
+// fileLocks أقفال داخل العملية فقط
+var fileLocks sync.Map // path -> *sync.Mutex
+
+// lockFile على الأنظمة بدون flock القفل داخل العملية فقط
+// NOTE: الخادم يعمل على Linux (Docker)، هذا فقط حتى يُبنى العميل على Windows
+func lockFile(path string) (unlock func(), err error) {
+	v, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock, nil
+}
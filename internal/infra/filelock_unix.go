@@ -0,0 +1,32 @@
+//go:build unix
+
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile يأخذ قفلاً حصرياً على path بين العمليات (flock)
+// NOTE: يُنشأ الملف إن لم يكن موجوداً، والقفل يُفك عند استدعاء unlock
+func lockFile(path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -1,6 +1,7 @@
 package infra
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -9,9 +10,18 @@ import (
 // الهدف: فصل منطق الملفات عن logic / work
 type FileSystem interface {
 	MkdirAll(path string) error
-	Create(path string) (*os.File, error)
-	Open(path string) (*os.File, error)
+	Create(path string) (io.WriteCloser, error)
+	Open(path string) (io.ReadCloser, error)
 	WriteFile(path string, data []byte) error
+	ReadFile(path string) ([]byte, error)
+	Rename(oldPath string, newPath string) error
+	Remove(path string) error
+	RemoveAll(path string) error
+	Stat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.FileInfo, error)
+
+	// Lock قفل حصري على path بين العمليات التي تشارك نفس القرص
+	Lock(path string) (unlock func(), err error)
 }
 
 // LocalFileSystem تنفيذ فعلي باستخدام نظام الملفات المحلي
@@ -28,7 +38,7 @@ func (fs *LocalFileSystem) MkdirAll(path string) error {
 }
 
 // Create ينشئ ملف مع التأكد من وجود المجلد الأب
-func (fs *LocalFileSystem) Create(path string) (*os.File, error) {
+func (fs *LocalFileSystem) Create(path string) (io.WriteCloser, error) {
 	dir := filepath.Dir(path)
 	if err := fs.MkdirAll(dir); err != nil {
 		return nil, err
@@ -37,7 +47,7 @@ func (fs *LocalFileSystem) Create(path string) (*os.File, error) {
 }
 
 // Open يفتح ملف موجود
-func (fs *LocalFileSystem) Open(path string) (*os.File, error) {
+func (fs *LocalFileSystem) Open(path string) (io.ReadCloser, error) {
 	return os.Open(path)
 }
 
@@ -49,3 +59,51 @@ func (fs *LocalFileSystem) WriteFile(path string, data []byte) error {
 	}
 	return os.WriteFile(path, data, 0644)
 }
+
+// ReadFile يقرأ محتوى ملف كامل
+func (fs *LocalFileSystem) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// Rename ينقل ملف (عملية ذرّية داخل نفس القرص)
+func (fs *LocalFileSystem) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+// Remove يحذف ملف واحد
+func (fs *LocalFileSystem) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// RemoveAll يحذف مجلد مع كل محتواه
+func (fs *LocalFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Stat يرجع معلومات الملف
+func (fs *LocalFileSystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// ReadDir يرجع معلومات محتويات مجلد (ملفات ومجلدات)
+func (fs *LocalFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue // حُذف أثناء القراءة
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Lock قفل flock على path (يُنشأ الملف إن لم يكن موجوداً)
+func (fs *LocalFileSystem) Lock(path string) (func(), error) {
+	return lockFile(path)
+}
@@ -0,0 +1,218 @@
+package infra
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFileSystem تنفيذ FileSystem في الذاكرة
+// NOTE: مخصص للاختبارات والتشغيل بدون قرص، المجلدات ضمنية
+type MemFileSystem struct {
+	mu    sync.Mutex
+	files map[string]memFile
+	locks sync.Map // path -> *sync.Mutex
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemFileSystem ينشئ FileSystem فارغ في الذاكرة
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{files: make(map[string]memFile)}
+}
+
+// MkdirAll لا يفعل شيئاً لأن المجلدات ضمنية
+func (m *MemFileSystem) MkdirAll(path string) error {
+	return nil
+}
+
+// Create ينشئ ملف، المحتوى يُحفظ عند Close
+func (m *MemFileSystem) Create(path string) (io.WriteCloser, error) {
+	return &memWriter{fs: m, path: filepath.Clean(path)}, nil
+}
+
+// Open يفتح ملف موجود للقراءة
+func (m *MemFileSystem) Open(path string) (io.ReadCloser, error) {
+	data, err := m.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// WriteFile يكتب ملف كامل
+func (m *MemFileSystem) WriteFile(path string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[filepath.Clean(path)] = memFile{
+		data:    append([]byte(nil), data...),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// ReadFile يقرأ ملف كامل
+func (m *MemFileSystem) ReadFile(path string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[filepath.Clean(path)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+// Rename ينقل ملف
+func (m *MemFileSystem) Rename(oldPath string, newPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[filepath.Clean(oldPath)]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, filepath.Clean(oldPath))
+	m.files[filepath.Clean(newPath)] = f
+	return nil
+}
+
+// Remove يحذف ملف واحد
+func (m *MemFileSystem) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[filepath.Clean(path)]; !ok {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(m.files, filepath.Clean(path))
+	return nil
+}
+
+// RemoveAll يحذف ملف أو مجلد مع كل محتواه
+func (m *MemFileSystem) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(path)
+	prefix := clean + string(filepath.Separator)
+	for p := range m.files {
+		if p == clean || strings.HasPrefix(p, prefix) {
+			delete(m.files, p)
+		}
+	}
+	return nil
+}
+
+// Stat يرجع معلومات ملف أو مجلد ضمني
+func (m *MemFileSystem) Stat(path string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clean := filepath.Clean(path)
+	if f, ok := m.files[clean]; ok {
+		return memFileInfo{name: filepath.Base(clean), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+
+	prefix := clean + string(filepath.Separator)
+	for p := range m.files {
+		if strings.HasPrefix(p, prefix) {
+			return memFileInfo{name: filepath.Base(clean), dir: true}, nil
+		}
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+// ReadDir يرجع محتويات مجلد ضمني، وقت تعديل المجلد هو أحدث ملف بداخله
+func (m *MemFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := filepath.Clean(path) + string(filepath.Separator)
+	children := make(map[string]memFileInfo)
+
+	for p, f := range m.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+
+		name, _, nested := strings.Cut(rest, string(filepath.Separator))
+		info := children[name]
+		info.name = name
+		info.dir = info.dir || nested
+		if !nested {
+			info.size = int64(len(f.data))
+		}
+		if f.modTime.After(info.modTime) {
+			info.modTime = f.modTime
+		}
+		children[name] = info
+	}
+
+	if len(children) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+
+	infos := make([]os.FileInfo, 0, len(children))
+	for _, info := range children {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Lock قفل داخل العملية فقط (لا توجد عمليات أخرى تشارك الذاكرة)
+func (m *MemFileSystem) Lock(path string) (func(), error) {
+	v, _ := m.locks.LoadOrStore(filepath.Clean(path), &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock, nil
+}
+
+// memWriter يجمع البيانات ثم يكتبها في الملف عند Close
+type memWriter struct {
+	fs   *MemFileSystem
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	return w.fs.WriteFile(w.path, w.buf.Bytes())
+}
+
+// memFileInfo تنفيذ بسيط لـ os.FileInfo
+type memFileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
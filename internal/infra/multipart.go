@@ -0,0 +1,111 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultMaxUploadSize is used when LM_MAX_UPLOAD_BYTES is not set (10GB).
+const DefaultMaxUploadSize = int64(10 << 30)
+
+// ErrUploadTooLarge is returned when an uploaded file exceeds the maximum size.
+var ErrUploadTooLarge = errors.New("upload exceeds maximum size")
+
+// MaxUploadSizeFromEnv يرجع الحد الأقصى لحجم الملف المرفوع (LM_MAX_UPLOAD_BYTES)
+// NOTE: 0 يعني بدون حد
+func MaxUploadSizeFromEnv() (int64, error) {
+	v := os.Getenv("LM_MAX_UPLOAD_BYTES")
+	if v == "" {
+		return DefaultMaxUploadSize, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid LM_MAX_UPLOAD_BYTES: %q", v)
+	}
+
+	return n, nil
+}
+
+// multipartOverhead هامش لحقول وحدود الـ multipart فوق حجم الملف نفسه
+const multipartOverhead = 64 << 10
+
+// CheckContentLength يرفض الطلب قبل قراءة أي بايت إذا أعلن حجماً أكبر من الحد
+// NOTE: maxSize = 0 يعني بدون حد، والطلبات بدون Content-Length تُفحص أثناء القراءة
+func CheckContentLength(r *http.Request, maxSize int64) error {
+	if maxSize > 0 && r.ContentLength > maxSize+multipartOverhead {
+		return fmt.Errorf("%w: %d bytes declared, limit is %d", ErrUploadTooLarge, r.ContentLength, maxSize)
+	}
+	return nil
+}
+
+// MultipartFile ملف داخل طلب multipart يُقرأ مباشرة من الشبكة
+type MultipartFile struct {
+	FileName string
+	Reader   io.Reader // يرجع ErrUploadTooLarge عند تجاوز الحد
+}
+
+// OpenMultipartFile يبحث عن الحقل field داخل الطلب ويرجعه كـ stream
+//
+// NOTE: لا شيء يُخزّن في الذاكرة أو في ملفات مؤقتة (بعكس ParseMultipartForm)،
+// لذلك يجب قراءة الملف قبل أي حقل بعده. maxSize = 0 يعني بدون حد.
+func OpenMultipartFile(r *http.Request, field string, maxSize int64) (*MultipartFile, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != field || part.FileName() == "" {
+			continue
+		}
+
+		var reader io.Reader = part
+		if maxSize > 0 {
+			reader = &sizeLimitedReader{r: part, remaining: maxSize}
+		}
+
+		return &MultipartFile{
+			FileName: filepath.Base(part.FileName()),
+			Reader:   reader,
+		}, nil
+	}
+}
+
+// sizeLimitedReader مثل io.LimitReader لكنه يرجع خطأ بدل EOF صامت
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrUploadTooLarge
+	}
+
+	// نقرأ بايت إضافي حتى نعرف هل تجاوز الملف الحد
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), ErrUploadTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,93 @@
+package infra
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// multipartRequest طلب فيه الملف data في الحقل field
+func multipartRequest(t *testing.T, field string, data []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile(field, "../cap.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestOpenMultipartFileSizeLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 100)
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		want    error
+	}{
+		{"exactly the limit", 100, nil},
+		{"one byte over", 99, ErrUploadTooLarge},
+		{"far over", 10, ErrUploadTooLarge},
+		{"no limit", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := OpenMultipartFile(multipartRequest(t, "pcapfile", data), "pcapfile", tt.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.FileName != "cap.pcap" {
+				t.Fatalf("file name %q, want cap.pcap", file.FileName)
+			}
+
+			got, err := io.ReadAll(file.Reader)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && !bytes.Equal(got, data) {
+				t.Fatalf("read %d bytes, want %d", len(got), len(data))
+			}
+			if tt.want != nil && int64(len(got)) > tt.maxSize {
+				t.Fatalf("read %d bytes past the limit %d", len(got), tt.maxSize)
+			}
+		})
+	}
+}
+
+func TestOpenMultipartFileMissingField(t *testing.T) {
+	_, err := OpenMultipartFile(multipartRequest(t, "other", []byte("x")), "pcapfile", 0)
+	if !errors.Is(err, http.ErrMissingFile) {
+		t.Fatalf("got %v, want http.ErrMissingFile", err)
+	}
+}
+
+func TestCheckContentLength(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+
+	req.ContentLength = 1000 + multipartOverhead
+	if err := CheckContentLength(req, 1000); err != nil {
+		t.Fatalf("limit plus overhead rejected: %v", err)
+	}
+
+	req.ContentLength++
+	if err := CheckContentLength(req, 1000); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("got %v, want ErrUploadTooLarge", err)
+	}
+	if err := CheckContentLength(req, 0); err != nil {
+		t.Fatalf("no limit rejected: %v", err)
+	}
+}
@@ -0,0 +1,484 @@
+package infra
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is matched by every QuotaExceededError.
+var ErrQuotaExceeded = errors.New("disk quota exceeded")
+
+// QuotaExceededError explains which limit a write would break.
+type QuotaExceededError struct {
+	Scope    string // "global" or the uploader name
+	Used     int64
+	Incoming int64
+	Limit    int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf(
+		"disk quota exceeded (%s): used %d + incoming %d > limit %d bytes",
+		e.Scope,
+		e.Used,
+		e.Incoming,
+		e.Limit,
+	)
+}
+
+// Unwrap allows errors.Is(err, ErrQuotaExceeded).
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaUsage is a snapshot of disk usage for operators.
+type QuotaUsage struct {
+	Roots            []string         `json:"roots"`
+	Used             int64            `json:"used"`
+	GlobalLimit      int64            `json:"global_limit"`
+	PerUploaderLimit int64            `json:"per_uploader_limit"`
+	Uploaders        map[string]int64 `json:"uploaders"`
+}
+
+// ownedFile is one ledger entry.
+type ownedFile struct {
+	Uploader string    `json:"uploader"`
+	Size     int64     `json:"size"`
+	Reserved time.Time `json:"reserved"`          // last Reserve call
+	OnDisk   bool      `json:"on_disk,omitempty"` // seen on disk by Refresh
+}
+
+// pendingReservationTTL is how long a reservation whose file was never
+// written is kept (e.g. the process crashed during the upload).
+const pendingReservationTTL = 24 * time.Hour
+
+// DiskQuota enforces a global and a per-uploader limit
+// over a set of directories.
+//
+// NOTE: The ledger file is shared: the API server and the chunk receiver
+// point at the same file and every change re-reads it under a file lock,
+// so reservations from one process count in the other.
+// Global usage is the ledger total plus the files under the roots that
+// are not in the ledger (measured on Refresh). Entries whose files were
+// deleted are dropped on Refresh. A limit of 0 means unlimited.
+// All file access (measuring, ledger, lock) goes through fs.
+type DiskQuota struct {
+	GlobalLimit      int64
+	PerUploaderLimit int64
+
+	fs         FileSystem
+	roots      []string
+	ledgerPath string
+
+	mu        sync.Mutex
+	untracked int64                // bytes under roots not in the ledger
+	owners    map[string]ownedFile // path -> owner
+}
+
+// NewDiskQuota creates a quota over roots and loads its ledger.
+func NewDiskQuota(fs FileSystem, ledgerPath string, globalLimit int64, perUploaderLimit int64, roots ...string) (*DiskQuota, error) {
+	q := &DiskQuota{
+		GlobalLimit:      globalLimit,
+		PerUploaderLimit: perUploaderLimit,
+		fs:               fs,
+		roots:            roots,
+		ledgerPath:       ledgerPath,
+		owners:           make(map[string]ownedFile),
+	}
+
+	if err := q.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// QuotaLedgerName is the ledger file name inside the uploads directory.
+const QuotaLedgerName = ".quota.json"
+
+// QuotaFromEnv creates a quota using LM_QUOTA_GLOBAL_BYTES
+// and LM_QUOTA_PER_UPLOADER_BYTES.
+//
+// NOTE: Returns nil without error when neither limit is set.
+// LM_QUOTA_LEDGER overrides ledgerPath; every process sharing the
+// disk must use the same ledger.
+func QuotaFromEnv(fs FileSystem, ledgerPath string, roots ...string) (*DiskQuota, error) {
+	if path := os.Getenv("LM_QUOTA_LEDGER"); path != "" {
+		ledgerPath = path
+	}
+
+	global, err := envBytes("LM_QUOTA_GLOBAL_BYTES")
+	if err != nil {
+		return nil, err
+	}
+
+	perUploader, err := envBytes("LM_QUOTA_PER_UPLOADER_BYTES")
+	if err != nil {
+		return nil, err
+	}
+
+	if global == 0 && perUploader == 0 {
+		return nil, nil
+	}
+
+	return NewDiskQuota(fs, ledgerPath, global, perUploader, roots...)
+}
+
+func envBytes(name string) (int64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, v)
+	}
+
+	return n, nil
+}
+
+// UploaderFromKey turns an API key into a stable uploader name
+// that is safe to store and log (the key itself is never kept).
+func UploaderFromKey(apiKey string) string {
+	if apiKey == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key-" + hex.EncodeToString(sum[:6])
+}
+
+// Refresh measures the roots again and drops ledger entries
+// for files that no longer exist.
+func (q *DiskQuota) Refresh() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.withLedger(func() (bool, error) {
+		var untracked int64
+		for _, root := range q.roots {
+			size, err := q.untrackedSize(root)
+			if err != nil {
+				return false, err
+			}
+			untracked += size
+		}
+		q.untracked = untracked
+
+		for path, owner := range q.owners {
+			info, err := q.fs.Stat(path)
+			if err != nil {
+				// الحجز قبل الكتابة: الملف لم يُكتب بعد
+				if owner.OnDisk || time.Since(owner.Reserved) > pendingReservationTTL {
+					delete(q.owners, path)
+				}
+				continue
+			}
+			// الحجز يبقى كما هو حتى يكتمل الملف (رفع قابل للاستئناف)
+			owner.Size = max(owner.Size, info.Size())
+			owner.OnDisk = true
+			q.owners[path] = owner
+		}
+
+		return true, nil
+	})
+}
+
+// Check reports whether size more bytes fit for the uploader,
+// without recording anything.
+func (q *DiskQuota) Check(uploader string, size int64) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.withLedger(func() (bool, error) {
+		return false, q.checkLocked(uploader, size)
+	})
+}
+
+// Reserve records that path (owned by uploader) will hold size bytes.
+// It fails with a QuotaExceededError when a limit would be exceeded.
+//
+// NOTE: Reserve before writing. Reserving an existing path replaces
+// its previous size, so the final size can be set once it is known.
+func (q *DiskQuota) Reserve(uploader string, path string, size int64) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	path = filepath.Clean(path)
+	return q.withLedger(func() (bool, error) {
+		old := q.owners[path]
+		if err := q.checkLocked(uploader, size-old.Size); err != nil {
+			return false, err
+		}
+
+		q.owners[path] = ownedFile{Uploader: uploader, Size: size, Reserved: time.Now(), OnDisk: old.OnDisk}
+		return true, nil
+	})
+}
+
+// Release forgets a path, or every path below it when it is a directory.
+//
+// NOTE: Call it after the files were deleted.
+func (q *DiskQuota) Release(path string) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	path = filepath.Clean(path)
+	prefix := path + string(filepath.Separator)
+	q.withLedger(func() (bool, error) {
+		changed := false
+		for p := range q.owners {
+			if p == path || strings.HasPrefix(p, prefix) {
+				delete(q.owners, p)
+				changed = true
+			}
+		}
+		return changed, nil
+	})
+}
+
+// Usage returns current usage after measuring the roots again.
+//
+// NOTE: A nil quota reports an empty, unlimited usage.
+func (q *DiskQuota) Usage() (QuotaUsage, error) {
+	if q == nil {
+		return QuotaUsage{Uploaders: map[string]int64{}}, nil
+	}
+
+	if err := q.Refresh(); err != nil {
+		return QuotaUsage{}, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := QuotaUsage{
+		Roots:            q.roots,
+		Used:             q.usedLocked(),
+		GlobalLimit:      q.GlobalLimit,
+		PerUploaderLimit: q.PerUploaderLimit,
+		Uploaders:        make(map[string]int64),
+	}
+	for _, owner := range q.owners {
+		usage.Uploaders[owner.Uploader] += owner.Size
+	}
+
+	return usage, nil
+}
+
+// untrackedSize measures the files below dir that are not in the ledger.
+func (q *DiskQuota) untrackedSize(dir string) (int64, error) {
+	infos, err := q.fs.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil // المجلد لم يُنشأ بعد أو حُذف أثناء الفحص
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() {
+			n, err := q.untrackedSize(path)
+			if err != nil {
+				return 0, err
+			}
+			size += n
+			continue
+		}
+		if strings.HasPrefix(path, q.ledgerPath) {
+			continue
+		}
+		if _, ok := q.owners[path]; !ok {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// usedLocked is the global usage: ledger entries plus untracked files.
+func (q *DiskQuota) usedLocked() int64 {
+	used := q.untracked
+	for _, owner := range q.owners {
+		used += owner.Size
+	}
+	return used
+}
+
+func (q *DiskQuota) checkLocked(uploader string, size int64) error {
+	if used := q.usedLocked(); q.GlobalLimit > 0 && used+size > q.GlobalLimit {
+		return &QuotaExceededError{
+			Scope:    "global",
+			Used:     used,
+			Incoming: size,
+			Limit:    q.GlobalLimit,
+		}
+	}
+
+	if q.PerUploaderLimit > 0 {
+		var used int64
+		for _, owner := range q.owners {
+			if owner.Uploader == uploader {
+				used += owner.Size
+			}
+		}
+		if used+size > q.PerUploaderLimit {
+			return &QuotaExceededError{
+				Scope:    uploader,
+				Used:     used,
+				Incoming: size,
+				Limit:    q.PerUploaderLimit,
+			}
+		}
+	}
+
+	return nil
+}
+
+// withLedger runs change on a fresh copy of the ledger while holding
+// the ledger file lock, and writes the ledger back when it changed.
+//
+// NOTE: q.mu must be held.
+func (q *DiskQuota) withLedger(change func() (bool, error)) error {
+	unlock, err := q.fs.Lock(q.ledgerPath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := q.loadLocked(); err != nil {
+		return err
+	}
+
+	changed, err := change()
+	if err != nil || !changed {
+		return err
+	}
+
+	return q.saveLocked()
+}
+
+// loadLocked reads the ledger written by any process.
+func (q *DiskQuota) loadLocked() error {
+	owners := make(map[string]ownedFile)
+
+	data, err := q.fs.ReadFile(q.ledgerPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &owners); err != nil {
+			return fmt.Errorf("corrupted quota ledger %s: %w", q.ledgerPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	q.owners = owners
+	return nil
+}
+
+// saveLocked writes the ledger (temp file + rename).
+func (q *DiskQuota) saveLocked() error {
+	data, err := json.Marshal(q.owners)
+	if err != nil {
+		return err
+	}
+
+	if err := q.fs.MkdirAll(filepath.Dir(q.ledgerPath)); err != nil {
+		return err
+	}
+
+	tmp := q.ledgerPath + ".tmp"
+	if err := q.fs.WriteFile(tmp, data); err != nil {
+		return err
+	}
+
+	return q.fs.Rename(tmp, q.ledgerPath)
+}
+
+/*
+========================
+RESERVING WRITER
+========================
+*/
+
+// reserveStep is how far a QuotaWriter reserves ahead of its writes.
+const reserveStep = 8 << 20
+
+// QuotaWriter reserves quota for path before every write, so a body of
+// unknown length (chunked request, compressed chunks) cannot go past
+// the limit. Call Commit with the final size once the file is complete.
+type QuotaWriter struct {
+	quota    *DiskQuota
+	uploader string
+	path     string
+	w        io.Writer
+
+	reserved int64
+	written  int64
+}
+
+// NewQuotaWriter reserves expected bytes (0 when unknown) for path
+// before anything is written.
+func (q *DiskQuota) NewQuotaWriter(uploader string, path string, expected int64, w io.Writer) (*QuotaWriter, error) {
+	qw := &QuotaWriter{quota: q, uploader: uploader, path: path, w: w}
+	if err := qw.reserve(max(expected, 0)); err != nil {
+		return nil, err
+	}
+	return qw, nil
+}
+
+func (qw *QuotaWriter) Write(p []byte) (int, error) {
+	if need := qw.written + int64(len(p)); need > qw.reserved {
+		// قرب الحد يُحجز ما تحتاجه هذه الكتابة فقط
+		if err := qw.reserve(max(need, qw.reserved+reserveStep)); err != nil {
+			if err := qw.reserve(need); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := qw.w.Write(p)
+	qw.written += int64(n)
+	return n, err
+}
+
+// Written returns the number of bytes written so far.
+func (qw *QuotaWriter) Written() int64 {
+	return qw.written
+}
+
+// Commit sets the reservation to the bytes actually written.
+func (qw *QuotaWriter) Commit() error {
+	return qw.reserve(qw.written)
+}
+
+func (qw *QuotaWriter) reserve(size int64) error {
+	if err := qw.quota.Reserve(qw.uploader, qw.path, size); err != nil {
+		return err
+	}
+	qw.reserved = size
+	return nil
+}
@@ -0,0 +1,173 @@
+package infra
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestQuota(t *testing.T, root string, global int64, perUploader int64) *DiskQuota {
+	t.Helper()
+	q, err := NewDiskQuota(NewLocalFileSystem(), filepath.Join(root, QuotaLedgerName), global, perUploader, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestQuotaLedgerSharedBetweenProcesses(t *testing.T) {
+	root := t.TempDir()
+	api := newTestQuota(t, root, 0, 100)
+	receiver := newTestQuota(t, root, 0, 100)
+
+	if err := api.Reserve("sensor", filepath.Join(root, "a.pcap"), 60); err != nil {
+		t.Fatal(err)
+	}
+
+	// الحجز من عملية أخرى يُحسب هنا أيضاً
+	err := receiver.Reserve("sensor", filepath.Join(root, "b.pcap"), 60)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+
+	if err := receiver.Reserve("sensor", filepath.Join(root, "b.pcap"), 40); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := api.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Uploaders["sensor"] != 100 {
+		t.Fatalf("api sees %d bytes for sensor, want 100", usage.Uploaders["sensor"])
+	}
+
+	receiver.Release(filepath.Join(root, "a.pcap"))
+	if err := api.Check("sensor", 60); err != nil {
+		t.Fatalf("released space not visible: %v", err)
+	}
+}
+
+func TestQuotaRefresh(t *testing.T) {
+	root := t.TempDir()
+	q := newTestQuota(t, root, 1000, 0)
+
+	// ملف غير مسجل في الـ ledger يُحسب في الاستهلاك الكلي
+	if err := os.WriteFile(filepath.Join(root, "other"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	written := filepath.Join(root, "written.pcap")
+	pending := filepath.Join(root, "pending.pcap")
+	if err := q.Reserve("sensor", written, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(written, make([]byte, 50), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Reserve("sensor", pending, 30); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := q.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Used != 180 {
+		t.Fatalf("used %d, want 180 (100 untracked + 50 written + 30 pending)", usage.Used)
+	}
+
+	// الملف المكتوب حُذف: يُزال من الـ ledger، والحجز قبل الكتابة يبقى
+	if err := os.Remove(written); err != nil {
+		t.Fatal(err)
+	}
+	usage, err = q.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Uploaders["sensor"] != 30 {
+		t.Fatalf("sensor has %d bytes, want 30", usage.Uploaders["sensor"])
+	}
+}
+
+func TestQuotaWriterReservesBeforeWriting(t *testing.T) {
+	root := t.TempDir()
+	q := newTestQuota(t, root, 0, 10)
+	path := filepath.Join(root, "cap.pcap")
+
+	var out bytes.Buffer
+	w, err := q.NewQuotaWriter("sensor", path, 0, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("too much")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+	if out.String() != "12345678" {
+		t.Fatalf("refused write reached the file: %q", out.String())
+	}
+
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := q.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Uploaders["sensor"] != 8 {
+		t.Fatalf("committed %d bytes, want 8", usage.Uploaders["sensor"])
+	}
+}
+
+func TestQuotaWriterExpectedSize(t *testing.T) {
+	root := t.TempDir()
+	q := newTestQuota(t, root, 0, 10)
+
+	_, err := q.NewQuotaWriter("sensor", filepath.Join(root, "big"), 11, &bytes.Buffer{})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want ErrQuotaExceeded", err)
+	}
+
+	var nilQuota *DiskQuota
+	w, err := nilQuota.NewQuotaWriter("sensor", "x", 1<<40, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(strings.Repeat("x", 100))); err != nil {
+		t.Fatalf("nil quota must not limit writes: %v", err)
+	}
+}
+
+func TestQuotaOnMemFileSystem(t *testing.T) {
+	fs := NewMemFileSystem()
+	if err := fs.WriteFile("data/temp/a/part_0", make([]byte, 40)); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewDiskQuota(fs, "data/uploads/.quota.json", 100, 0, "data/temp", "data/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Reserve("sensor", "data/uploads/cap.pcap", 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Check("sensor", 20); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want ErrQuotaExceeded (40 on disk + 50 reserved)", err)
+	}
+
+	// الـ ledger يُكتب في نفس الـ FileSystem وليس على القرص
+	if _, err := fs.Stat("data/uploads/.quota.json"); err != nil {
+		t.Fatalf("ledger not written to the filesystem: %v", err)
+	}
+	if _, err := os.Stat("data/uploads/.quota.json"); err == nil {
+		t.Fatal("ledger written to the local disk")
+	}
+}
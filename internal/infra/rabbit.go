@@ -2,29 +2,50 @@ package infra
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// ErrPublishNacked is returned when the broker refuses a confirmed publish.
+var ErrPublishNacked = errors.New("message was not acknowledged by the broker")
+
 // RabbitClient represents a RabbitMQ client.
 // It holds the connection and channel used for messaging.
 //
 // NOTE: This struct is responsible only for messaging logic.
 // TODO: Add automatic reconnection support.
 type RabbitClient struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	user      string // broker user, sent as user-id on confirmed publishes
+	senderKey []byte // signs senderHeader on retried messages (see sender)
 }
 
+// SenderKeyEnv names the secret that signs the original sender of
+// retried messages. Every server consuming the same queues needs the
+// same value; without it a random key is used per process.
+const SenderKeyEnv = "LM_SENDER_KEY"
+
 // NewRabbitClient creates a new RabbitMQ connection
 // and prepares a channel for publishing and consuming messages.
 //
 // NOTE: Call Close() when the client is no longer needed.
 // FIXME: No custom timeout is configured for the connection.
 func NewRabbitClient(url string) (*RabbitClient, error) {
+	uri, err := amqp.ParseURI(url)
+	if err != nil {
+		return nil, err
+	}
+
 	conn, err := amqp.Dial(url)
 	if err != nil {
 		return nil, err
@@ -35,12 +56,36 @@ func NewRabbitClient(url string) (*RabbitClient, error) {
 		return nil, err
 	}
 
+	senderKey, err := senderKeyFromEnv()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	return &RabbitClient{
-		conn:    conn,
-		channel: ch,
+		conn:      conn,
+		channel:   ch,
+		user:      uri.Username,
+		senderKey: senderKey,
 	}, nil
 }
 
+// senderKeyFromEnv reads SenderKeyEnv or creates a random key.
+//
+// NOTE: With a random key, messages still in the retry queue after a
+// restart lose their original sender and are charged to our own user.
+func senderKeyFromEnv() ([]byte, error) {
+	if key := os.Getenv(SenderKeyEnv); key != "" {
+		return []byte(key), nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 // Close safely closes the RabbitMQ channel and connection.
 //
 // NOTE: Should be called when shutting down the server.
@@ -57,15 +102,7 @@ func (r *RabbitClient) Close() {
 // Now updated to use durable = true to match ConsumeMessages.
 func (r *RabbitClient) PublishMessage(queueName string, message string) error {
 	// 1. التأكد من وجود الطابور بنفس إعدادات المستهلك (Durable: true)
-	_, err := r.channel.QueueDeclare(
-		queueName,
-		true,  // durable: يجب أن تكون true لتتطابق مع دالة الاستقبال
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,
-	)
-	if err != nil {
+	if err := r.declareQueue(queueName); err != nil {
 		return err
 	}
 
@@ -118,15 +155,7 @@ func (r *RabbitClient) ConsumeMessages(
 ) {
 	// 1. التأكد من وجود الطابور وتفعيل خاصية البقاء (Durable: true)
 	// هذا يمنع خطأ "queue not found" ويحمي الطابور من الحذف عند رسترت الأرنب
-	_, err := r.channel.QueueDeclare(
-		queueName,
-		true,  // durable: الطابور يبقى محفوظاً في القرص
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,
-	)
-	if err != nil {
+	if err := r.declareQueue(queueName); err != nil {
 		log.Printf("Failed to declare queue: %v", err)
 		return
 	}
@@ -155,3 +184,485 @@ func (r *RabbitClient) ConsumeMessages(
 		}
 	}()
 }
+
+// declareQueue makes sure a durable queue exists.
+//
+// NOTE: Publishers and consumers must declare the queue with the same settings.
+func (r *RabbitClient) declareQueue(queueName string) error {
+	_, err := r.channel.QueueDeclare(
+		queueName,
+		true,  // durable: الطابور يبقى محفوظاً في القرص
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		nil,
+	)
+	return err
+}
+
+// EnableConfirms puts the channel into publisher confirm mode.
+//
+// NOTE: Required before calling PublishWithConfirm.
+func (r *RabbitClient) EnableConfirms() error {
+	return r.channel.Confirm(false)
+}
+
+// PublishWithConfirm sends a binary message to a queue and waits
+// until the broker confirms that it has taken responsibility for it.
+//
+// NOTE: The channel must be in confirm mode (see EnableConfirms).
+// The message carries the broker user as user-id; RabbitMQ refuses
+// a user-id that differs from the connection user, so consumers can
+// trust it (see ConsumeWithSender).
+func (r *RabbitClient) PublishWithConfirm(
+	ctx context.Context,
+	queueName string,
+	contentType string,
+	body []byte,
+) error {
+	if err := r.declareQueue(queueName); err != nil {
+		return err
+	}
+
+	confirm, err := r.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"",        // exchange
+		queueName, // routing key
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  contentType,
+			Body:         body,
+			DeliveryMode: amqp.Persistent,
+			UserId:       r.user,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// الانتظار حتى يؤكد الأرنب استلام الرسالة
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+
+	return nil
+}
+
+// Delivery settings of ConsumeWithAck.
+const (
+	// DefaultPrefetch is how many unacknowledged messages a consumer holds.
+	DefaultPrefetch = 8
+
+	// MaxDeliveryAttempts is how often a message is tried before it is dead-lettered.
+	MaxDeliveryAttempts = 5
+
+	// retryDelay is how long a failed message waits in the retry queue.
+	retryDelay = 10 * time.Second
+
+	// attemptsHeader counts failed deliveries of a message.
+	attemptsHeader = "x-lm-attempts"
+
+	// errorHeader holds the last error of a dead-lettered message.
+	errorHeader = "x-lm-error"
+
+	// senderHeader keeps the original user-id of a retried message.
+	senderHeader = "x-lm-sender"
+
+	// senderSigHeader holds the HMAC of senderHeader and the body.
+	senderSigHeader = "x-lm-sender-sig"
+
+	// redeliverTimeout is how long a retry or dead-letter publish waits
+	// for the broker confirm.
+	redeliverTimeout = 30 * time.Second
+)
+
+// permanentError marks a handler error that a retry cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err so ConsumeWithAck dead-letters the message
+// at once instead of retrying it (e.g. a corrupted message).
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// RetryQueueName is where failed messages of queueName wait before
+// they go back to queueName.
+func RetryQueueName(queueName string) string {
+	return queueName + ".retry"
+}
+
+// DeadLetterQueueName is where messages of queueName that cannot be
+// processed are kept for operators.
+func DeadLetterQueueName(queueName string) string {
+	return queueName + ".dead"
+}
+
+// ConsumeWithAck listens to messages from a queue and acknowledges
+// each message only after the processor succeeds.
+//
+// NOTE: At most DefaultPrefetch messages are delivered before they are
+// acknowledged. A failed message goes to RetryQueueName, which sends it
+// back to the queue after retryDelay. Errors marked with Permanent, and
+// messages that failed MaxDeliveryAttempts times, go to
+// DeadLetterQueueName instead, so a bad message cannot block the queue
+// and is still not lost. The original is acknowledged only after the
+// broker confirmed the copy; otherwise it is requeued.
+func (r *RabbitClient) ConsumeWithAck(
+	queueName string,
+	processor func([]byte) error,
+) error {
+	return r.ConsumeWithSender(queueName, func(body []byte, _ string) error {
+		return processor(body)
+	})
+}
+
+// ConsumeWithSender works like ConsumeWithAck and also passes the
+// broker user that published the message (empty when unknown).
+//
+// NOTE: The sender comes from the user-id property, which RabbitMQ
+// validates, so it cannot be forged by the publisher.
+func (r *RabbitClient) ConsumeWithSender(
+	queueName string,
+	processor func(body []byte, sender string) error,
+) error {
+	if err := r.declareQueue(queueName); err != nil {
+		return err
+	}
+
+	if err := r.declareRetryQueues(queueName); err != nil {
+		return err
+	}
+
+	// عدد الرسائل غير المؤكدة لدى المستهلك (الباقي يبقى في الطابور)
+	if err := r.channel.Qos(DefaultPrefetch, 0, false); err != nil {
+		return err
+	}
+
+	// قناة منفصلة بتأكيد النشر لنقل الرسائل الفاشلة
+	// (القناة الرئيسية تُستخدم أيضاً للنشر بدون تأكيد)
+	confirms, err := r.conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := confirms.Confirm(false); err != nil {
+		confirms.Close()
+		return err
+	}
+
+	msgs, err := r.channel.Consume(
+		queueName,
+		"",    // consumer tag
+		false, // auto-ack: التأكيد يدوي بعد نجاح المعالجة
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		confirms.Close()
+		return err
+	}
+
+	publish := confirmedPublisher(confirms)
+	go func() {
+		defer confirms.Close()
+		log.Printf("Successfully started consuming from: %s", queueName)
+		for d := range msgs {
+			r.handleDelivery(queueName, d, processor, publish)
+		}
+	}()
+
+	return nil
+}
+
+// publishFunc publishes msg to a queue and returns after the broker
+// confirmed it.
+type publishFunc func(ctx context.Context, queueName string, msg amqp.Publishing) error
+
+// confirmedPublisher publishes on ch, which must be in confirm mode.
+func confirmedPublisher(ch *amqp.Channel) publishFunc {
+	return func(ctx context.Context, queueName string, msg amqp.Publishing) error {
+		confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queueName, false, false, msg)
+		if err != nil {
+			return err
+		}
+
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			return ErrPublishNacked
+		}
+		return nil
+	}
+}
+
+// handleDelivery processes one message and settles it.
+//
+// NOTE: A failed message is acknowledged only when its copy in the
+// retry or dead-letter queue was confirmed, so it is never lost.
+func (r *RabbitClient) handleDelivery(
+	queueName string,
+	d amqp.Delivery,
+	processor func(body []byte, sender string) error,
+	publish publishFunc,
+) {
+	err := processor(d.Body, r.sender(d))
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+
+	log.Printf("Failed to process message from %s: %v", queueName, err)
+	if err := r.redeliver(publish, queueName, d, err); err != nil {
+		// لم يؤكد الأرنب النسخة، الرسالة تعود إلى الطابور نفسه
+		log.Printf("Failed to move message from %s, requeueing: %v", queueName, err)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
+
+// declareRetryQueues creates the retry and dead-letter queues of queueName.
+//
+// NOTE: The retry queue has no consumer: messages expire after retryDelay
+// and RabbitMQ dead-letters them back to queueName.
+func (r *RabbitClient) declareRetryQueues(queueName string) error {
+	_, err := r.channel.QueueDeclare(
+		RetryQueueName(queueName),
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-message-ttl":             int64(retryDelay / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return r.declareQueue(DeadLetterQueueName(queueName))
+}
+
+// redeliver publishes a failed message to the retry or dead-letter queue
+// and waits for the broker confirm (at most redeliverTimeout).
+func (r *RabbitClient) redeliver(publish publishFunc, queueName string, d amqp.Delivery, cause error) error {
+	attempts := deliveryAttempts(d.Headers) + 1
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[attemptsHeader] = int32(attempts)
+	sender := r.sender(d)
+	headers[senderHeader] = sender
+	headers[senderSigHeader] = r.signSender(sender, d.Body)
+
+	target := RetryQueueName(queueName)
+	if IsPermanent(cause) || attempts >= MaxDeliveryAttempts {
+		target = DeadLetterQueueName(queueName)
+		headers[errorHeader] = cause.Error()
+		log.Printf("Message from %s dead-lettered after %d attempts: %v", queueName, attempts, cause)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redeliverTimeout)
+	defer cancel()
+
+	return publish(ctx, target, amqp.Publishing{
+		ContentType:  d.ContentType,
+		Headers:      headers,
+		Body:         d.Body,
+		DeliveryMode: amqp.Persistent,
+		UserId:       r.user,
+	})
+}
+
+// sender returns the broker user that published d.
+//
+// NOTE: Retried messages are republished by this client, so their
+// user-id is ours and the original one is read from senderHeader.
+// A client that shares our broker user (e.g. both use the default
+// guest) could set that header too, so it is trusted only with a valid
+// senderSigHeader, which needs senderKey.
+func (r *RabbitClient) sender(d amqp.Delivery) string {
+	if d.UserId == "" || d.UserId != r.user || len(r.senderKey) == 0 {
+		return d.UserId
+	}
+
+	original, ok := d.Headers[senderHeader].(string)
+	if !ok {
+		return d.UserId
+	}
+	sig, _ := d.Headers[senderSigHeader].(string)
+	if !hmac.Equal([]byte(sig), []byte(r.signSender(original, d.Body))) {
+		log.Printf("Ignoring unsigned %s header from %s", senderHeader, d.UserId)
+		return d.UserId
+	}
+	return original
+}
+
+// signSender signs the original sender of a message body.
+func (r *RabbitClient) signSender(sender string, body []byte) string {
+	mac := hmac.New(sha256.New, r.senderKey)
+	mac.Write([]byte(sender))
+	mac.Write([]byte{0})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryAttempts reads attemptsHeader (0 for a first delivery).
+func deliveryAttempts(headers amqp.Table) int {
+	switch v := headers[attemptsHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// directReplyQueue is RabbitMQ's built-in pseudo queue for RPC replies.
+const directReplyQueue = "amq.rabbitmq.reply-to"
+
+// Call sends a request to a queue and waits for a single reply (RPC).
+//
+// NOTE: Uses RabbitMQ direct reply-to on a short-lived channel,
+// so it does not interfere with the client's main channel.
+func (r *RabbitClient) Call(ctx context.Context, queueName string, body []byte) ([]byte, error) {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	replies, err := ch.Consume(
+		directReplyQueue,
+		"",    // consumer tag
+		true,  // auto-ack: مطلوب مع direct reply-to
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	correlationID := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	err = ch.PublishWithContext(
+		ctx,
+		"",        // exchange
+		queueName, // routing key
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: correlationID,
+			ReplyTo:       directReplyQueue,
+			Body:          body,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case d, ok := <-replies:
+			if !ok {
+				return nil, errors.New("reply channel closed")
+			}
+			if d.CorrelationId == correlationID {
+				return d.Body, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ServeRPC answers requests sent with Call.
+//
+// NOTE: The handler result is sent back to the caller's reply queue.
+// Handler errors are logged and the request is dropped,
+// so the caller ends with its own timeout.
+func (r *RabbitClient) ServeRPC(
+	queueName string,
+	handler func([]byte) ([]byte, error),
+) error {
+	if err := r.declareQueue(queueName); err != nil {
+		return err
+	}
+
+	msgs, err := r.channel.Consume(
+		queueName,
+		"",    // consumer tag
+		true,  // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		log.Printf("Successfully started serving RPC on: %s", queueName)
+		for d := range msgs {
+			reply, err := handler(d.Body)
+			if err != nil {
+				log.Printf("RPC handler failed on %s: %v", queueName, err)
+				continue
+			}
+
+			if d.ReplyTo == "" {
+				continue
+			}
+
+			err = r.channel.PublishWithContext(
+				context.Background(),
+				"",        // exchange
+				d.ReplyTo, // routing key
+				false,
+				false,
+				amqp.Publishing{
+					ContentType:   "application/json",
+					CorrelationId: d.CorrelationId,
+					Body:          reply,
+				},
+			)
+			if err != nil {
+				log.Printf("Failed to send RPC reply on %s: %v", queueName, err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPermanent(t *testing.T) {
+	cause := errors.New("bad message")
+
+	if IsPermanent(cause) {
+		t.Fatal("plain error reported as permanent")
+	}
+	if Permanent(nil) != nil {
+		t.Fatal("Permanent(nil) must stay nil")
+	}
+
+	wrapped := fmt.Errorf("chunk 3: %w", Permanent(cause))
+	if !IsPermanent(wrapped) {
+		t.Fatal("wrapped permanent error not detected")
+	}
+	if !errors.Is(wrapped, cause) {
+		t.Fatal("permanent error must unwrap to its cause")
+	}
+	if wrapped.Error() != "chunk 3: bad message" {
+		t.Fatalf("unexpected message %q", wrapped.Error())
+	}
+}
+
+func TestDeliveryAttempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"first delivery", nil, 0},
+		{"int32", amqp.Table{attemptsHeader: int32(2)}, 2},
+		{"int64", amqp.Table{attemptsHeader: int64(4)}, 4},
+		{"wrong type", amqp.Table{attemptsHeader: "3"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deliveryAttempts(tt.headers); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryQueueNames(t *testing.T) {
+	if got := RetryQueueName("file_chunks_queue"); got != "file_chunks_queue.retry" {
+		t.Fatalf("retry queue %q", got)
+	}
+	if got := DeadLetterQueueName("file_chunks_queue"); got != "file_chunks_queue.dead" {
+		t.Fatalf("dead-letter queue %q", got)
+	}
+}
+
+func TestSenderFromUserID(t *testing.T) {
+	r := &RabbitClient{user: "lm-server", senderKey: []byte("secret")}
+	body := []byte("chunk")
+	signed := amqp.Table{senderHeader: "sensor1", senderSigHeader: r.signSender("sensor1", body)}
+
+	tests := []struct {
+		name string
+		d    amqp.Delivery
+		want string
+	}{
+		{"client message", amqp.Delivery{UserId: "sensor1"}, "sensor1"},
+		{"no user-id", amqp.Delivery{}, ""},
+		{"retried by us", amqp.Delivery{UserId: "lm-server", Headers: signed, Body: body}, "sensor1"},
+		{"forged header", amqp.Delivery{UserId: "sensor2", Headers: signed, Body: body}, "sensor2"},
+		{"our own message", amqp.Delivery{UserId: "lm-server"}, "lm-server"},
+		// عميل يستخدم نفس مستخدم السيرفر (مثلاً guest) بدون المفتاح
+		{"unsigned header on our user", amqp.Delivery{UserId: "lm-server", Headers: amqp.Table{senderHeader: "sensor1"}, Body: body}, "lm-server"},
+		{"signature of another body", amqp.Delivery{UserId: "lm-server", Headers: signed, Body: []byte("other")}, "lm-server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.sender(tt.d); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingAcknowledger records how a delivery was settled.
+type recordingAcknowledger struct {
+	acked   bool
+	requeue bool
+	nacked  bool
+}
+
+func (a *recordingAcknowledger) Ack(uint64, bool) error { a.acked = true; return nil }
+func (a *recordingAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+func (a *recordingAcknowledger) Reject(_ uint64, requeue bool) error {
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+
+func TestHandleDeliveryAcksOnlyAfterConfirm(t *testing.T) {
+	r := &RabbitClient{user: "lm-server", senderKey: []byte("secret")}
+	failing := func([]byte, string) error { return errors.New("disk full") }
+
+	tests := []struct {
+		name       string
+		processor  func([]byte, string) error
+		publishErr error
+		wantTarget string
+		wantAck    bool
+	}{
+		{"processed", func([]byte, string) error { return nil }, nil, "", true},
+		{"retry confirmed", failing, nil, "chunks.retry", true},
+		{"retry nacked", failing, ErrPublishNacked, "chunks.retry", false},
+		{"dead-letter confirmed", func([]byte, string) error { return Permanent(errors.New("bad")) }, nil, "chunks.dead", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordingAcknowledger{}
+			target := ""
+			publish := func(_ context.Context, queueName string, _ amqp.Publishing) error {
+				target = queueName
+				return tt.publishErr
+			}
+
+			r.handleDelivery("chunks", amqp.Delivery{Acknowledger: ack, UserId: "sensor1"}, tt.processor, publish)
+
+			if target != tt.wantTarget {
+				t.Fatalf("published to %q, want %q", target, tt.wantTarget)
+			}
+			if ack.acked != tt.wantAck {
+				t.Fatalf("acked %t, want %t", ack.acked, tt.wantAck)
+			}
+			if !tt.wantAck && !(ack.nacked && ack.requeue) {
+				t.Fatal("unconfirmed message must be requeued")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package infra
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits throughput in bytes per second (token bucket).
+//
+// NOTE: One limiter can be shared by several goroutines, the limit
+// then applies to their sum. Requests larger than the bucket are
+// allowed and paid back by waiting, so big chunks still pass.
+// A nil limiter never waits.
+type RateLimiter struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter, or nil when bytesPerSec <= 0 (unlimited).
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	// الـ burst ثانية واحدة من الحد، حتى لا تُرسل دفعة كبيرة بعد توقف طويل
+	return &RateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may be sent, or ctx is done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedReader throttles reads through a RateLimiter.
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+// NewRateLimitedReader wraps r so reads follow the limiter.
+//
+// NOTE: Reads are capped at 32KB so the rate stays smooth.
+// Returns r itself when limiter is nil.
+func NewRateLimitedReader(r io.Reader, limiter *RateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: limiter}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.limiter.WaitN(context.Background(), n)
+	}
+	return n, err
+}
@@ -0,0 +1,55 @@
+package infra
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitsForDeficit(t *testing.T) {
+	l := NewRateLimiter(1000)
+	ctx := context.Background()
+
+	// الـ burst ثانية كاملة، فأول 1000 بايت بدون انتظار
+	start := time.Now()
+	if err := l.WaitN(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("burst waited %v", d)
+	}
+
+	start = time.Now()
+	if err := l.WaitN(ctx, 200); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("200 bytes at 1000 B/s waited only %v", d)
+	}
+}
+
+func TestRateLimiterStopsOnContext(t *testing.T) {
+	l := NewRateLimiter(1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := l.WaitN(ctx, 10_000); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("canceled wait took %v", d)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	if l := NewRateLimiter(0); l != nil {
+		t.Fatal("limit 0 created a limiter")
+	}
+
+	var l *RateLimiter
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -10,6 +10,9 @@ import (
 
 var ctx = context.Background()
 
+// uploadActivityKey sorted set: FileID → آخر نشاط (unix)، يستخدمه الـ Janitor
+const uploadActivityKey = "uploads:activity"
+
 type RedisService struct {
 	client *redis.Client
 }
@@ -27,7 +30,10 @@ func (r *RedisService) StoreChunk(fileID string, data []byte) error {
 	key := fmt.Sprintf("file:%s:chunks", fileID)
 	err := r.client.RPush(ctx, key, data).Err()
 	r.client.Expire(ctx, key, 2*time.Hour) // حذف آلي بعد ساعتين لحماية الذاكرة
-	return err
+	if err != nil {
+		return err
+	}
+	return r.TouchUpload(fileID)
 }
 
 // 2. تجميع الملف كاملاً عند وصول EOF
@@ -54,6 +60,41 @@ func (r *RedisService) GroupByIP(ip string, data string) error {
 // 4. تنظيف Redis بعد النقل لـ MongoDB
 func (r *RedisService) ClearFile(fileID string) {
 	r.client.Del(ctx, fmt.Sprintf("file:%s:chunks", fileID))
+	r.client.ZRem(ctx, uploadActivityKey, fileID)
+}
+
+// 5. تسجيل آخر نشاط للرفع
+// NOTE: الـ sorted set بدون TTL، لذلك يبقى الرفع معروفاً للـ Janitor
+// حتى بعد انتهاء صلاحية قائمة القطع
+func (r *RedisService) TouchUpload(fileID string) error {
+	return r.client.ZAdd(ctx, uploadActivityKey, redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: fileID,
+	}).Err()
+}
+
+// 6. الرفع الذي لم يحدث فيه نشاط منذ before
+func (r *RedisService) StaleUploads(before time.Time) (map[string]time.Time, error) {
+	entries, err := r.client.ZRangeByScoreWithScores(ctx, uploadActivityKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", before.Unix()),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stale := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		if id, ok := e.Member.(string); ok {
+			stale[id] = time.Unix(int64(e.Score), 0).UTC()
+		}
+	}
+	return stale, nil
+}
+
+// 7. عدد القطع المخزنة لملف
+func (r *RedisService) ChunkCount(fileID string) (int64, error) {
+	return r.client.LLen(ctx, fmt.Sprintf("file:%s:chunks", fileID)).Result()
 }
 
 func (r *RedisService) Ping() error {
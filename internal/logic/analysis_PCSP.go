@@ -2,17 +2,18 @@ package logic
 
 import (
 	"LM-Gate/internal/infra"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcapgo"
 )
 
 // الإعدادات العامة
@@ -22,13 +23,44 @@ const (
 	OutputDir          = "/data/uploads/chunks"  // مجلد تخزين الأجزاء
 	CleanupInterval    = 10 * time.Minute        // فحص المجلد كل 10 دقائق
 	MaxFileAge         = 30 * time.Minute        // حذف الملفات التي عمرها أكثر من 30 دقيقة
+	UploadsDir         = "/data/uploads"         // مجلد الرفع المشترك (Docker volume)
 )
 
+// UploadsQuota حصة القرص الخاصة بمجلد الرفع (تُقرأ من متغيرات البيئة مرة واحدة)
+// NOTE: تُرجع nil عندما لا توجد حدود مضبوطة
+// السجل (ledger) مشترك مع مستقبل الأجزاء (lm serve) على نفس الـ volume
+var UploadsQuota = sync.OnceValues(func() (*infra.DiskQuota, error) {
+	return infra.QuotaFromEnv(infra.NewLocalFileSystem(), filepath.Join(UploadsDir, infra.QuotaLedgerName), UploadsDir)
+})
+
 // --- [ الدالات الخاصة بـ API ] ---
 
 func handlePcapSplit(c *gin.Context) {
-	// 1️⃣ جلب الملف المرفوع
-	fileHeader, err := c.FormFile("pcapfile")
+	// 1️⃣ الحد الأقصى لحجم الملف
+	maxSize, err := infra.MaxUploadSizeFromEnv()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// رفض مبكر إذا أعلن الطلب حجماً أكبر من الحد
+	if err := infra.CheckContentLength(c.Request, maxSize); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, errorBody(err))
+		return
+	}
+
+	// استراتيجية التقسيم من ?split= (مثل time:60s أو flow:8)
+	// وصيغة الأجزاء من ?output= (pcap أو pcapng)
+	opts, err := ParseProcessOptions(c.Query("split"), c.Query("output"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err))
+		return
+	}
+
+	// 2️⃣ فتح الملف كـ stream (بدون تخزينه في الذاكرة)
+	src, err := infra.OpenMultipartFile(c.Request, "pcapfile", maxSize)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "لم يتم العثور على ملف مرفوع",
@@ -36,25 +68,68 @@ func handlePcapSplit(c *gin.Context) {
 		return
 	}
 
-	// 2️⃣ فتح الملف
-	src, err := fileHeader.Open()
+	// 3️⃣ حفظ الملف (مع فحص الحصة) وتسجيل مهمة التقسيم
+	// المعالجة نفسها تتم في الـ worker وليس داخل الطلب
+	job, err := SubmitUpload(
+		src.Reader,
+		src.FileName,
+		Uploader(c),
+		max(c.Request.ContentLength, 0),
+		opts,
+	)
+	// صيغة غير مدعومة أو حجم زائد: تُكتشف أثناء الرفع وقبل الحفظ
+	if status := infra.CaptureErrorStatus(err); status != 0 {
+		c.JSON(status, errorBody(err))
+		return
+	}
+	if errors.Is(err, infra.ErrQuotaExceeded) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, ErrPublishFailed) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":  err.Error(),
+			"job_id": job.ID,
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "فشل فتح الملف",
+			"error": err.Error(),
 		})
 		return
 	}
-	defer src.Close()
 
-	// 3️⃣ إنشاء FileSystem (Dependency Injection)
-	fs := infra.NewLocalFileSystem()
+	// 4️⃣ الرد فوراً برقم المهمة
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":     job.ID,
+		"status":     job.Status,
+		"status_url": "/jobs/" + job.ID,
+	})
+}
 
-	// 4️⃣ معالجة ملف PCAP
-	createdFiles, err := ProcessPcap(
-		fs,
-		src,
-		fileHeader.Filename,
-	)
+// errorBody رد الخطأ مع رمز ثابت (code) لأخطاء الصيغة والحجم
+func errorBody(err error) gin.H {
+	body := gin.H{"error": err.Error()}
+	if code := infra.CaptureErrorCode(err); code != "" {
+		body["code"] = code
+	}
+	switch {
+	case errors.Is(err, ErrInvalidSplit):
+		body["code"] = CodeInvalidSplit
+	case errors.Is(err, ErrInvalidOutput):
+		body["code"] = CodeInvalidOutput
+	}
+	return body
+}
+
+// handleQuota يعرض استهلاك القرص الحالي وحدود الحصة للمشغّلين
+// NOTE: الاستهلاك يشمل كل المفاتيح، لذلك المسار لمفاتيح admin فقط
+func handleQuota(c *gin.Context) {
+	quota, err := UploadsQuota()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -62,36 +137,93 @@ func handlePcapSplit(c *gin.Context) {
 		return
 	}
 
-	// 5️⃣ الرد على المستخدم
-	c.JSON(http.StatusOK, gin.H{
-		"status":       "success",
-		"total_chunks": len(createdFiles),
-		"chunks":       createdFiles,
-		"note":         "سيتم حذف هذه الملفات تلقائيًا بعد 30 دقيقة",
-	})
+	usage, err := quota.Usage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
 }
 
 // --- [ منطق معالجة الـ PCAP ] ---
 
 func ProcessPcap(fs infra.FileSystem, inputFile io.Reader, originalName string) ([]string, error) {
+	return ProcessPcapWith(fs, inputFile, originalName, DefaultProcessOptions())
+}
 
-	reader, err := pcapgo.NewReader(inputFile)
+// openChunk ملف جزء مفتوح للكتابة
+type openChunk struct {
+	writer chunkWriter
+	base   string // اسم الجزء من الـ Splitter (بدون _if<N>)
+}
+
+// ProcessPcapWith يقسم الملف حسب opts.Split (انظر splitter.go)
+// ويكتب الأجزاء بصيغة opts.Output (انظر capture_io.go)
+func ProcessPcapWith(fs infra.FileSystem, inputFile io.Reader, originalName string, opts ProcessOptions) ([]string, error) {
+
+	// الملف بعد فك الضغط لا يتجاوز حد الرفع غير المضغوط (LM_MAX_UPLOAD_BYTES)
+	maxSize, err := infra.MaxUploadSizeFromEnv()
 	if err != nil {
-		return nil, fmt.Errorf("تنسيق ملف PCAP غير صالح")
+		return nil, err
 	}
 
+	// التعرف على الصيغة (pcap/pcapng/مضغوط) وفك الضغط إذا لزم
+	format, capture, err := infra.OpenCapture(inputFile, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := openCaptureSource(format, capture)
+	if err != nil {
+		return nil, &infra.CaptureError{Code: infra.CodeUnsupportedFormat, Message: err.Error()}
+	}
+
+	// الـ Splitter يُنشأ مع أول حزمة: نوع ربطها هو النوع الأساسي
+	// (في pcapng لا تُعرف الواجهات قبل ذلك)
+	var splitter Splitter
+	var baseLinkType layers.LinkType
+	var baseNanos bool
+
+	// ملخص سجلات الأصل للتحقق من الأجزاء في النهاية
+	digest := newRecordDigest()
+	ordered := true // الأجزاء بالترتيب = الأصل (تقسيم متتالي بنوع ربط واحد)
+
 	var createdFiles []string
-	var currentWriter *pcapgo.Writer
-	var currentFile io.Closer // 👈 لم نعد مربوطين بـ os.File
+	chunks := make(map[string]*openChunk) // 👈 أكثر من ملف مفتوح في flow/host
+	lastBase := ""
 	packetCount := 0
-	chunkID := 0
+
+	// إغلاق كل الملفات المفتوحة عند أي خروج
+	defer func() {
+		for _, chunk := range chunks {
+			chunk.writer.Close()
+		}
+	}()
+
+	// closeChunks يغلق الأجزاء التي يختارها done
+	closeChunks := func(done func(*openChunk) bool) error {
+		for key, chunk := range chunks {
+			if !done(chunk) {
+				continue
+			}
+			delete(chunks, key)
+			if err := chunk.writer.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	fmt.Println("🚀 Starting PCAP processing")
+	fmt.Printf("🔎 Format: %s\n", format)
 	fmt.Printf("📍 Output directory: %s\n", OutputDir)
-	fmt.Printf("📦 Max packets per chunk: %d\n", MaxPacketsPerChunk)
+	fmt.Printf("📦 Split strategy: %s (%s chunks)\n", opts.Split, opts.Output)
 
 	for {
-		data, ci, err := reader.ReadPacketData()
+		data, ci, err := source.ReadPacketData()
 		if err == io.EOF {
 			break
 		}
@@ -99,45 +231,80 @@ func ProcessPcap(fs infra.FileSystem, inputFile io.Reader, originalName string)
 			return nil, fmt.Errorf("failed reading packet: %w", err)
 		}
 
-		// إنشاء ملف جديد عند بداية كل chunk
-		if packetCount%MaxPacketsPerChunk == 0 {
+		linkType := source.PacketLinkType(ci)
+		if splitter == nil {
+			baseLinkType = linkType
+			baseNanos = source.PacketNanos(ci)
+			splitter = opts.Split.NewSplitter(baseLinkType, chunkRecordSize(opts.Output))
+			ordered = splitter.Sequential()
+		}
+		digest.Add(ci, linkType, data)
+
+		base := splitter.Assign(ci, data)
 
-			if currentFile != nil {
-				currentFile.Close()
+		// أجزاء انتهت كل اتصالاتها (session)
+		if finisher, ok := splitter.(ChunkFinisher); ok {
+			for _, done := range finisher.Finished() {
+				if err := closeChunks(func(chunk *openChunk) bool { return chunk.base == done }); err != nil {
+					return nil, err
+				}
 			}
+		}
+
+		// التقسيم المتتالي: الأجزاء السابقة انتهت
+		if splitter.Sequential() && lastBase != "" && base != lastBase {
+			if err := closeChunks(func(*openChunk) bool { return true }); err != nil {
+				return nil, err
+			}
+		}
+		lastBase = base
+
+		// ملف pcap يحمل نوع ربط ودقة توقيت واحدة: واجهات pcapng المختلفة في ملفات منفصلة
+		// (حزم nano في ملف micro تفقد دقتها)
+		key := base
+		if opts.Output == OutputPcap && (linkType != baseLinkType || source.PacketNanos(ci) != baseNanos) {
+			key = fmt.Sprintf("%s_if%d", base, ci.InterfaceIndex)
+			ordered = false
+		}
 
-			chunkName := fmt.Sprintf("chunk_%d_%s", chunkID, originalName)
+		chunk, ok := chunks[key]
+		if !ok {
+			chunkName := chunkFileName(key, originalName, opts.Output)
 			fullPath := filepath.Join(OutputDir, chunkName)
 
-			file, writer, err := createNewChunk(fs, fullPath, reader.LinkType())
+			writer, err := createNewChunk(fs, fullPath, opts.Output, source, ci)
 			if err != nil {
 				return nil, err
 			}
 
-			currentFile = file
-			currentWriter = writer
+			chunk = &openChunk{writer: writer, base: base}
+			chunks[key] = chunk
 
 			fmt.Printf("🧩 Created new chunk file: %s\n", fullPath)
 
 			createdFiles = append(createdFiles, chunkName)
-			chunkID++
 		}
 
-		if err := currentWriter.WritePacket(ci, data); err != nil {
+		if err := chunk.writer.WritePacket(ci, data); err != nil {
 			return nil, fmt.Errorf("write packet failed: %w", err)
 		}
 
 		packetCount++
 	}
 
-	if currentFile != nil {
-		currentFile.Close()
+	if err := closeChunks(func(*openChunk) bool { return true }); err != nil {
+		return nil, err
 	}
 
 	if packetCount == 0 {
 		return nil, fmt.Errorf("pcap file contains no packets")
 	}
 
+	// 🔍 قراءة الأجزاء من جديد ومقارنتها مع الأصل
+	if err := verifyChunks(fs, createdFiles, digest, ordered); err != nil {
+		return nil, err
+	}
+
 	// 🟢 ملخص نهائي
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println("📂 Chunk files summary:")
@@ -148,29 +315,14 @@ func ProcessPcap(fs infra.FileSystem, inputFile io.Reader, originalName string)
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Printf("📊 Total packets processed: %d\n", packetCount)
 	fmt.Printf("📁 Total chunks created: %d\n", len(createdFiles))
-	fmt.Printf("📦 Packets per chunk: %d\n", MaxPacketsPerChunk)
+	fmt.Printf("🔍 Chunks verified against the original (ordered: %t)\n", ordered)
+	fmt.Printf("📦 Split strategy: %s (%s chunks)\n", opts.Split, opts.Output)
 	fmt.Printf("📍 Stored at: %s\n", OutputDir)
 	fmt.Println("✅ PCAP processing completed successfully")
 
 	return createdFiles, nil
 }
 
-func createNewChunk(fs infra.FileSystem, path string, linkType layers.LinkType) (io.Closer, *pcapgo.Writer, error) {
-
-	file, err := fs.Create(path)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	writer := pcapgo.NewWriter(file)
-	if err := writer.WriteFileHeader(65536, linkType); err != nil {
-		file.Close()
-		return nil, nil, err
-	}
-
-	return file, writer, nil
-}
-
 // --- [ دالات التنظيف والحذف ] ---
 
 func startCleanupWorker(interval time.Duration, maxAge time.Duration) {
@@ -192,6 +344,9 @@ func startCleanupWorker(interval time.Duration, maxAge time.Duration) {
 					log.Printf("🗑️ تم حذف ملف قديم: %s", file.Name())
 				}
 			}
+
+			// المهام المنتهية تبقى مدة أطول من ملفاتها الناتجة
+			DefaultJobStore().Prune(JobRetention)
 		}
 	}()
 }
@@ -199,9 +354,43 @@ func RunAPIServer() {
 	os.MkdirAll(OutputDir, os.ModePerm)
 	startCleanupWorker(CleanupInterval, MaxFileAge)
 
+	// الرفع القابل للاستكمال ينشر نفس الحدث الذي يستهلكه الـ worker
+	resumable := NewResumableUploads(ResumableDir, UploadsDir, DefaultPublisher())
+	resumable.Jobs = DefaultJobStore()
+	if quota, err := UploadsQuota(); err == nil {
+		resumable.Quota = quota
+	} else {
+		log.Printf("⚠️ quota disabled for resumable uploads: %v", err)
+	}
+	if maxSize, err := infra.MaxUploadSizeFromEnv(); err == nil {
+		resumable.MaxSize = maxSize
+	} else {
+		log.Printf("⚠️ %v, using default max upload size", err)
+		resumable.MaxSize = infra.DefaultMaxUploadSize
+	}
+
+	// كل المسارات تتطلب مفتاح API صالح
+	keys, err := DefaultAPIKeys()
+	if err != nil {
+		log.Fatalf("❌ API keys: %v", err)
+	}
+	if keys.AdminKey == "" {
+		log.Printf("⚠️ LM_ADMIN_KEY is not set, only stored keys can authenticate")
+	}
+
 	r := gin.Default()
-	r.POST("/split-pcap", handlePcapSplit)
+	registerRoutes(r, keys, resumable, DefaultJobStore())
 
 	fmt.Println("🚀 السيرفر يعمل على المنفذ :8080")
 	r.Run(":8080")
 }
+
+// registerRoutes يربط كل مسارات الـ API خلف RequireAPIKey
+func registerRoutes(r *gin.Engine, keys *infra.APIKeys, resumable *ResumableUploads, jobs *JobStore) {
+	r.Use(RequireAPIKey(keys))
+	r.POST("/split-pcap", handlePcapSplit)
+	r.GET("/quota", RequireAdmin(), handleQuota)
+	resumable.Register(r)
+	jobs.Register(r)
+	(&KeyAdmin{Keys: keys}).Register(r)
+}
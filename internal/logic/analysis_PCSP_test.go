@@ -0,0 +1,59 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// testCapture ملف pcap فيه n حزمة بحجم size
+func testCapture(t *testing.T, n int, size int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, size)
+	for i := 0; i < n; i++ {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), CaptureLength: size, Length: size}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestProcessPcapLimitsDecompressedSize(t *testing.T) {
+	capture := testCapture(t, 100, 1000)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(capture)
+	gz.Close()
+
+	// الملف المضغوط أصغر من الحد، وبعد فك الضغط أكبر منه
+	t.Setenv("LM_MAX_UPLOAD_BYTES", "10000")
+	if int64(compressed.Len()) >= 10000 {
+		t.Fatalf("compressed capture is %d bytes, test needs less than the limit", compressed.Len())
+	}
+
+	_, err := ProcessPcapWith(infra.NewMemFileSystem(), &compressed, "cap.pcap.gz", DefaultProcessOptions())
+	if !errors.Is(err, infra.ErrUploadTooLarge) {
+		t.Fatalf("got %v, want ErrUploadTooLarge", err)
+	}
+
+	t.Setenv("LM_MAX_UPLOAD_BYTES", "0")
+	if _, err := ProcessPcapWith(infra.NewMemFileSystem(), bytes.NewReader(capture), "cap.pcap", DefaultProcessOptions()); err != nil {
+		t.Fatalf("plain capture: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// مصادقة مفاتيح الـ API
+//
+//	كل المسارات تتطلب X-API-Key صالحاً          → 401 إذا كان ناقصاً أو خاطئاً
+//	مسارات /admin/keys تتطلب مفتاحاً admin       → 403
+//
+// المفاتيح تُخزّن في ملف (الافتراضي) أو في Redis:
+//
+//	LM_API_KEYS_STORE=file|redis
+//	LM_API_KEYS_FILE   مسار الملف (الافتراضي /data/uploads/.apikeys.json)
+//	LM_ADMIN_KEY       مفتاح admin أولي غير مخزّن لإنشاء أول المفاتيح
+
+// apiKeyContextKey مكان المفتاح الحالي داخل gin.Context
+const apiKeyContextKey = "api_key"
+
+// defaultAPIKeysFile ملف المفاتيح داخل الـ volume المشترك
+const defaultAPIKeysFile = UploadsDir + "/.apikeys.json"
+
+// DefaultAPIKeys مدير المفاتيح من متغيرات البيئة (يُقرأ مرة واحدة)
+var DefaultAPIKeys = sync.OnceValues(APIKeysFromEnv)
+
+// APIKeysFromEnv ينشئ مدير المفاتيح حسب LM_API_KEYS_STORE
+func APIKeysFromEnv() (*infra.APIKeys, error) {
+	adminKey := os.Getenv("LM_ADMIN_KEY")
+
+	switch store := os.Getenv("LM_API_KEYS_STORE"); store {
+	case "", "file":
+		path := os.Getenv("LM_API_KEYS_FILE")
+		if path == "" {
+			path = defaultAPIKeysFile
+		}
+		return infra.NewAPIKeys(infra.NewFileAPIKeyStore(path), adminKey), nil
+
+	case "redis":
+		addr := os.Getenv("REDIS_ADDR")
+		if addr == "" {
+			addr = "redis:6379"
+		}
+		redis := infra.NewRedisService(addr)
+		if err := redis.Ping(); err != nil {
+			return nil, fmt.Errorf("API key store: %w", err)
+		}
+		return infra.NewAPIKeys(infra.NewRedisAPIKeyStore(redis), adminKey), nil
+
+	default:
+		return nil, fmt.Errorf("invalid LM_API_KEYS_STORE: %q", store)
+	}
+}
+
+// RequireAPIKey يرفض أي طلب بدون مفتاح صالح ويسجل من نفّذه
+func RequireAPIKey(keys *infra.APIKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, err := keys.Authenticate(c.GetHeader("X-API-Key"))
+		if err != nil {
+			status, message := authErrorStatus(err)
+			log.Printf("🔒 %s %s rejected: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.AbortWithStatusJSON(status, gin.H{"error": message})
+			return
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+
+		// سجل التدقيق: أي مفتاح نفّذ أي عملية
+		log.Printf("🔑 %s (%s) %s %s → %d", key.ID, key.Name, c.Request.Method, c.Request.URL.Path, c.Writer.Status())
+	}
+}
+
+// RequireAdmin يسمح فقط لمفاتيح admin (بعد RequireAPIKey)
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := CurrentKey(c); key == nil || !key.Admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin API key required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentKey يرجع المفتاح الذي صادق الطلب
+func CurrentKey(c *gin.Context) *infra.APIKey {
+	v, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return nil
+	}
+	key, _ := v.(*infra.APIKey)
+	return key
+}
+
+// Uploader اسم المفتاح الذي نفّذ الطلب (للحصة والمهام)
+func Uploader(c *gin.Context) string {
+	if key := CurrentKey(c); key != nil {
+		return key.ID
+	}
+	return infra.UploaderFromKey(c.GetHeader("X-API-Key"))
+}
+
+// authErrorStatus يحوّل أخطاء المصادقة إلى رموز HTTP
+// NOTE: لا نميّز بين مفتاح خاطئ ومفتاح غير موجود في الرد
+func authErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, infra.ErrAPIKeyRevoked), errors.Is(err, infra.ErrAPIKeyExpired):
+		return http.StatusUnauthorized, err.Error()
+	case errors.Is(err, infra.ErrInvalidAPIKey):
+		return http.StatusUnauthorized, "missing or invalid X-API-Key"
+	}
+	return http.StatusInternalServerError, "API key store unavailable"
+}
+
+/*
+========================
+ADMIN: إدارة المفاتيح
+========================
+*/
+
+// KeyAdmin مسارات إدارة المفاتيح
+//
+//	GET    /admin/keys       قائمة المفاتيح (بدون hashes)
+//	POST   /admin/keys       إنشاء مفتاح {"name", "admin", "ttl"}  → المفتاح يظهر مرة واحدة
+//	PATCH  /admin/keys/:id   تغيير الانتهاء {"expires_at"} أو {"ttl"}، "" = بدون انتهاء
+//	DELETE /admin/keys/:id   إلغاء المفتاح فوراً
+type KeyAdmin struct {
+	Keys *infra.APIKeys
+}
+
+// Register يربط مسارات الإدارة بالـ router
+func (a *KeyAdmin) Register(r gin.IRoutes) {
+	r.GET("/admin/keys", RequireAdmin(), a.handleList)
+	r.POST("/admin/keys", RequireAdmin(), a.handleCreate)
+	r.PATCH("/admin/keys/:id", RequireAdmin(), a.handleExpire)
+	r.DELETE("/admin/keys/:id", RequireAdmin(), a.handleRevoke)
+}
+
+func (a *KeyAdmin) handleList(c *gin.Context) {
+	keys, err := a.Keys.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func (a *KeyAdmin) handleCreate(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name"`
+		Admin bool   `json:"admin"`
+		TTL   string `json:"ttl"` // مثل "720h"، فارغ = بدون انتهاء
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name مطلوب"})
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl غير صالح"})
+			return
+		}
+		ttl = d
+	}
+
+	raw, key, err := a.Keys.Create(req.Name, req.Admin, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	key.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{"key": raw, "info": key})
+}
+
+func (a *KeyAdmin) handleExpire(c *gin.Context) {
+	var req struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		TTL       *string    `json:"ttl"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var at time.Time
+	switch {
+	case req.ExpiresAt != nil:
+		at = *req.ExpiresAt
+	case req.TTL != nil && *req.TTL != "":
+		d, err := time.ParseDuration(*req.TTL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl غير صالح"})
+			return
+		}
+		at = time.Now().Add(d)
+	case req.TTL == nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at أو ttl مطلوب"})
+		return
+	}
+
+	key, err := a.Keys.Expire(c.Param("id"), at)
+	if errors.Is(err, infra.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func (a *KeyAdmin) handleRevoke(c *gin.Context) {
+	key, err := a.Keys.Revoke(c.Param("id"))
+	if errors.Is(err, infra.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
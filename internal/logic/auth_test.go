@@ -0,0 +1,50 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuotaRequiresAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+
+	keys := infra.NewAPIKeys(infra.NewFileAPIKeyStore(filepath.Join(dir, "keys.json")), "")
+	raw, _, err := keys.Create("sensor", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	registerRoutes(r, keys, newTestUploads(t), NewJobStore(dir))
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"no key", "", http.StatusUnauthorized},
+		{"wrong key", "lm_wrong", http.StatusUnauthorized},
+		{"non-admin key", raw, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/quota", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// قراءة وكتابة ملفات الالتقاط (pcap و pcapng)
+//
+// الإدخال: الصيغة تُعرف من infra.OpenCapture
+// الإخراج: OutputPcap (الافتراضي) أو OutputPcapNG
+//
+// pcapng قد يحتوي عدة واجهات بأنواع ربط مختلفة:
+//   - إخراج pcapng: كل الواجهات (الاسم، الوصف، التعليق، الفلتر) تُنسخ لكل جزء،
+//     وتعليقات الحزم تبقى مع حزمها (انظر packet_comments.go)
+//   - إخراج pcap: نوع ربط ودقة توقيت واحدة لكل ملف، فحزم الواجهات التي يختلف
+//     نوعها أو دقتها (micro/nano) عن أول حزمة تذهب إلى chunk_<key>_if<N>_<name>
+
+const (
+	OutputPcap   = infra.FormatPcap
+	OutputPcapNG = infra.FormatPcapNG
+)
+
+// ErrInvalidOutput يُرجع عند صيغة إخراج غير معروفة
+var ErrInvalidOutput = errors.New("invalid output")
+
+// CodeInvalidOutput رمز الخطأ في رد الـ API
+const CodeInvalidOutput = "invalid_output"
+
+// ProcessOptions إعدادات معالجة ملف واحد
+type ProcessOptions struct {
+	Split  SplitSpec
+	Output string
+}
+
+// DefaultProcessOptions كل MaxPacketsPerChunk حزمة في ملفات pcap
+func DefaultProcessOptions() ProcessOptions {
+	return ProcessOptions{Split: DefaultSplitSpec(), Output: OutputPcap}
+}
+
+// ParseProcessOptions يقرأ نص التقسيم وصيغة الإخراج (الفارغ = الافتراضي)
+func ParseProcessOptions(split string, output string) (ProcessOptions, error) {
+	spec, err := ParseSplitSpec(split)
+	if err != nil {
+		return ProcessOptions{}, err
+	}
+
+	switch output = strings.ToLower(strings.TrimSpace(output)); output {
+	case "":
+		output = OutputPcap
+	case OutputPcap, OutputPcapNG:
+	default:
+		return ProcessOptions{}, fmt.Errorf("%w %q: output must be pcap or pcapng", ErrInvalidOutput, output)
+	}
+
+	return ProcessOptions{Split: spec, Output: output}, nil
+}
+
+/*
+========================
+READERS
+========================
+*/
+
+// captureSource قارئ الحزم مع معلومات الواجهات
+type captureSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+
+	// PacketLinkType نوع ربط الحزمة حسب واجهتها
+	PacketLinkType(ci gopacket.CaptureInfo) layers.LinkType
+
+	// PacketNanos هل توقيت واجهة الحزمة يحتاج ملف pcap بالنانوثانية
+	PacketNanos(ci gopacket.CaptureInfo) bool
+
+	// Interfaces الواجهات المعروفة حتى الآن (pcapng قد يضيف واجهات أثناء القراءة)
+	Interfaces() []pcapgo.NgInterface
+
+	// Section معلومات الملف (التطبيق، النظام، التعليق)
+	Section() pcapgo.NgSectionInfo
+}
+
+// openCaptureSource ينشئ القارئ المناسب لصيغة الملف
+func openCaptureSource(format infra.CaptureFormat, r io.Reader) (captureSource, error) {
+	switch format.Container {
+	case infra.FormatPcapNG:
+		// NOTE: مع WantMixedLinkType لا تُقرأ الواجهات قبل أول حزمة،
+		// والملفات بعدة sections تعيد ترقيم الواجهات (غير مدعوم في إخراج pcapng)
+		comments := &commentScanner{}
+		reader, err := pcapgo.NewNgReader(io.TeeReader(r, comments), pcapgo.NgReaderOptions{
+			// الحزم من واجهات بأنواع ربط مختلفة لا تُحذف،
+			// ونوع الربط يصل في ci.AncillaryData[0]
+			WantMixedLinkType:  true,
+			SkipUnknownVersion: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &ngSource{NgReader: reader, comments: comments}, nil
+	}
+
+	reader, err := pcapgo.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pcapSource{Reader: reader, nanos: format.Precision == "nano"}, nil
+}
+
+// pcapSource
+// NOTE: دقة التوقيت من format وليس من Reader.Resolution()
+// لأنها معكوسة في pcapgo v1.1.19 (ترجع micro لملفات nano)
+type pcapSource struct {
+	*pcapgo.Reader
+	nanos bool
+}
+
+func (s *pcapSource) PacketLinkType(gopacket.CaptureInfo) layers.LinkType {
+	return s.LinkType()
+}
+
+func (s *pcapSource) PacketNanos(gopacket.CaptureInfo) bool {
+	return s.nanos
+}
+
+// Interfaces ملف pcap فيه واجهة واحدة من الـ header
+func (s *pcapSource) Interfaces() []pcapgo.NgInterface {
+	resolution := pcapgo.NgResolution(6)
+	if s.nanos {
+		resolution = 9
+	}
+	return []pcapgo.NgInterface{{
+		Name:                "intf0",
+		LinkType:            s.LinkType(),
+		SnapLength:          s.Snaplen(),
+		TimestampResolution: resolution,
+	}}
+}
+
+func (s *pcapSource) Section() pcapgo.NgSectionInfo {
+	return pcapgo.DefaultNgWriterOptions.SectionInfo
+}
+
+// ngSource
+// NOTE: تعليقات الحزمة تُضاف إلى ci.AncillaryData بعد نوع الربط (packetComments).
+// التعليقات مربوطة بالحزم حسب الترتيب فقط، لذلك أي اختلاف بين الـ scanner
+// و NgReader (حزمة لم يرها، أو عدد مختلف في النهاية) خطأ وليس تعليقات ضائعة
+type ngSource struct {
+	*pcapgo.NgReader
+	comments *commentScanner
+	read     int
+}
+
+func (s *ngSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.NgReader.ReadPacketData()
+	if err == io.EOF && (s.comments.failed || s.comments.packets != s.read) {
+		return nil, ci, fmt.Errorf("%w: read %d packets, found %d", errCommentsOutOfSync, s.read, s.comments.packets)
+	}
+	if err != nil {
+		return nil, ci, err
+	}
+	s.read++
+
+	comments, ok := s.comments.next()
+	if !ok {
+		return nil, ci, fmt.Errorf("%w: no blocks for packet %d", errCommentsOutOfSync, s.read)
+	}
+	if len(comments) > 0 {
+		ci.AncillaryData = append(ci.AncillaryData, packetComments(comments))
+	}
+
+	// SPB بدون توقيت: NgReader يرجع time.Time{} الذي لا يُكتب في pcap أو pcapng
+	if ci.Timestamp.IsZero() {
+		ci.Timestamp = time.Unix(0, 0).UTC()
+	}
+	return data, ci, nil
+}
+
+func (s *ngSource) PacketLinkType(ci gopacket.CaptureInfo) layers.LinkType {
+	return packetLinkType(ci, s.LinkType())
+}
+
+func (s *ngSource) PacketNanos(ci gopacket.CaptureInfo) bool {
+	intf, err := s.Interface(ci.InterfaceIndex)
+	return err == nil && needsNanos(intf.TimestampResolution)
+}
+
+func (s *ngSource) Interfaces() []pcapgo.NgInterface {
+	interfaces := make([]pcapgo.NgInterface, s.NInterfaces())
+	for i := range interfaces {
+		interfaces[i], _ = s.Interface(i)
+	}
+	return interfaces
+}
+
+func (s *ngSource) Section() pcapgo.NgSectionInfo {
+	return s.SectionInfo()
+}
+
+// packetLinkType نوع ربط الحزمة: من pcapng (عدة واجهات) أو نوع الملف
+func packetLinkType(ci gopacket.CaptureInfo, fallback layers.LinkType) layers.LinkType {
+	if len(ci.AncillaryData) > 0 {
+		if linkType, ok := ci.AncillaryData[0].(layers.LinkType); ok {
+			return linkType
+		}
+	}
+	return fallback
+}
+
+/*
+========================
+WRITERS
+========================
+*/
+
+// chunkFileName اسم ملف الجزء بامتداد صيغة الإخراج
+// (cap.pcapng.gz → chunk_0_cap.pcap عند إخراج pcap)
+func chunkFileName(key string, originalName string, output string) string {
+	name := originalName
+	for _, ext := range []string{".gz", ".bz2", ".pcapng", ".pcap", ".cap"} {
+		if len(name) > len(ext) && strings.EqualFold(name[len(name)-len(ext):], ext) {
+			name = name[:len(name)-len(ext)]
+		}
+	}
+	return fmt.Sprintf("chunk_%s_%s.%s", key, name, output)
+}
+
+// chunkRecordSize حجم الحزمة كما يكتبها chunkWriter بصيغة output
+// (بدون رأس الملف والواجهات)، يستخدمه تقسيم bytes
+func chunkRecordSize(output string) func(gopacket.CaptureInfo) int64 {
+	if output == OutputPcapNG {
+		// EPB مع تعليقات الحزمة
+		return func(ci gopacket.CaptureInfo) int64 {
+			return int64(enhancedPacketLength(ci.CaptureLength, commentsOf(ci)))
+		}
+	}
+	// رأس الحزمة في pcap: التوقيت (8) و caplen و length
+	return func(ci gopacket.CaptureInfo) int64 {
+		return int64(16 + ci.CaptureLength)
+	}
+}
+
+// chunkWriter ملف جزء مفتوح للكتابة
+type chunkWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+	Close() error
+}
+
+// maxSnaplen يُكتب في pcap عندما لا يحدد الأصل snaplen (واجهة pcapng بـ 0)
+// وهو أكبر snaplen يستخدمه libpcap
+const maxSnaplen = 262144
+
+// createNewChunk ينشئ ملف الجزء بالصيغة المطلوبة
+// ci أول حزمة في الجزء: واجهتها تحدد نوع الربط و snaplen ودقة التوقيت (لـ pcap)
+func createNewChunk(fs infra.FileSystem, path string, output string, source captureSource, ci gopacket.CaptureInfo) (chunkWriter, error) {
+
+	file, err := fs.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if output == OutputPcapNG {
+		writer, err := newNgChunkWriter(file, source)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return writer, nil
+	}
+
+	// نفس header الأصل: snaplen ونوع الربط ودقة التوقيت (micro/nano)
+	// NOTE: pcapgo يكتب little-endian دائماً، ترتيب البايتات فقط قد يختلف
+	var intf pcapgo.NgInterface
+	if interfaces := source.Interfaces(); ci.InterfaceIndex < len(interfaces) {
+		intf = interfaces[ci.InterfaceIndex]
+	}
+
+	snaplen := intf.SnapLength
+	if snaplen == 0 {
+		snaplen = maxSnaplen
+	}
+
+	writer := pcapgo.NewWriter(file)
+	if source.PacketNanos(ci) {
+		writer = pcapgo.NewWriterNanos(file)
+	}
+
+	if err := writer.WriteFileHeader(snaplen, source.PacketLinkType(ci)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &pcapChunkWriter{file: file, writer: writer}, nil
+}
+
+type pcapChunkWriter struct {
+	file   io.Closer
+	writer *pcapgo.Writer
+}
+
+func (c *pcapChunkWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	return c.writer.WritePacket(ci, data)
+}
+
+func (c *pcapChunkWriter) Close() error {
+	return c.file.Close()
+}
+
+// ngChunkWriter يكتب pcapng بنفس واجهات الملف الأصلي وبنفس أرقامها
+// NOTE: NgWriter يكتب الـ section والواجهات فقط، والحزم تُكتب هنا
+// مع تعليقاتها لأن pcapgo لا يكتب options الحزم.
+// NgWriter له bufio خاص فيُفرغ في out بعد كل واجهة للحفاظ على الترتيب
+type ngChunkWriter struct {
+	file   io.Closer
+	out    *bufio.Writer
+	writer *pcapgo.NgWriter
+	source captureSource
+	added  int // عدد الواجهات المكتوبة في الجزء
+	buf    []byte
+}
+
+func newNgChunkWriter(file io.WriteCloser, source captureSource) (*ngChunkWriter, error) {
+	interfaces := source.Interfaces()
+	if len(interfaces) == 0 {
+		return nil, errors.New("pcapng capture has no interfaces")
+	}
+
+	out := bufio.NewWriter(file)
+	writer, err := pcapgo.NewNgWriterInterface(out, ngInterface(interfaces[0]), pcapgo.NgWriterOptions{
+		SectionInfo: source.Section(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	c := &ngChunkWriter{file: file, out: out, writer: writer, source: source, added: 1}
+	if err := c.addInterfaces(len(interfaces) - 1); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// addInterfaces يضيف واجهات الملف الأصلي حتى الرقم last
+func (c *ngChunkWriter) addInterfaces(last int) error {
+	if last < c.added {
+		return nil
+	}
+
+	interfaces := c.source.Interfaces()
+	if last >= len(interfaces) {
+		return fmt.Errorf("interface %d not present in capture", last)
+	}
+	for ; c.added <= last; c.added++ {
+		if _, err := c.writer.AddInterface(ngInterface(interfaces[c.added])); err != nil {
+			return err
+		}
+	}
+	return c.writer.Flush()
+}
+
+func (c *ngChunkWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	if ci.InterfaceIndex < 0 {
+		return fmt.Errorf("invalid interface %d", ci.InterfaceIndex)
+	}
+	if err := c.addInterfaces(ci.InterfaceIndex); err != nil {
+		return err
+	}
+	// نفس فحوص pcapgo.NgWriter.WritePacket
+	if ci.CaptureLength != len(data) {
+		return fmt.Errorf("capture length %d does not match data length %d", ci.CaptureLength, len(data))
+	}
+	if ci.CaptureLength > ci.Length {
+		return fmt.Errorf("invalid capture info %+v: capture length > length", ci)
+	}
+
+	c.buf = appendEnhancedPacket(c.buf[:0], ci, data, commentsOf(ci))
+	_, err := c.out.Write(c.buf)
+	return err
+}
+
+func (c *ngChunkWriter) Close() error {
+	if err := c.out.Flush(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
+
+// needsNanos هل دقة التوقيت أدق من الميكروثانية (أو غير عشرية)
+// فتحتاج pcap بالنانوثانية حتى لا تضيع
+func needsNanos(resolution pcapgo.NgResolution) bool {
+	return resolution.Binary() || resolution.Exponent() > 6
+}
+
+// ngInterface نسخة الواجهة للكتابة
+// NOTE: التوقيتات المقروءة تشمل TimestampOffset، فلا يُكتب مرة أخرى
+func ngInterface(intf pcapgo.NgInterface) pcapgo.NgInterface {
+	intf.TimestampOffset = 0
+	return intf
+}
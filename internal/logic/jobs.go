@@ -0,0 +1,472 @@
+package logic
+
+import (
+	"LM-Gate/internal/events"
+	"LM-Gate/internal/infra"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// مهام المعالجة غير المتزامنة
+//
+//	رفع الملف → Enqueue (queued) → pcap_processing_queue → worker (running)
+//	→ succeeded + artifacts  أو  failed + error
+//
+// GET /jobs/:id يرجع حالة المهمة.
+//
+// NOTE: المهام ملفات JSON في JobsDir داخل الـ volume المشترك،
+// لأن سيرفر الـ API والـ worker عمليتان منفصلتان، والكتابة تتم تحت قفل
+// ملف (jobsLockFile) مشترك بينهما.
+// GET /jobs/:id مسموح فقط لصاحب الرفع أو لمفتاح admin (403).
+
+const (
+	// JobsDir مجلد حالة المهام (داخل الـ volume المشترك)
+	JobsDir = UploadsDir + "/jobs"
+
+	// JobRetention مدة الاحتفاظ بالمهام المنتهية
+	JobRetention = 24 * time.Hour
+
+	jobsLockFile = ".lock"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobOwner    = errors.New("job belongs to another API key")
+	ErrJobFinished = errors.New("job already finished")
+
+	errJobNotRunning = errors.New("job is not running")
+)
+
+// JobStatus حالة المهمة
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job مهمة معالجة لملف مرفوع واحد
+type Job struct {
+	ID        string               `json:"id"`
+	Status    JobStatus            `json:"status"`
+	FileName  string               `json:"file_name"`
+	Path      string               `json:"path"`
+	Uploader  string               `json:"uploader"` // المفتاح الذي رفع الملف
+	Size      int64                `json:"size"`
+	Format    *infra.CaptureFormat `json:"format,omitempty"` // من أول بايتات الرفع
+	Split     string               `json:"split"`            // استراتيجية التقسيم (ParseSplitSpec)
+	Output    string               `json:"output,omitempty"` // صيغة الأجزاء: pcap أو pcapng
+	Progress  int                  `json:"progress"`         // نسبة مئوية 0-100
+	Error     string               `json:"error,omitempty"`
+	Artifacts []string             `json:"artifacts"` // الملفات الناتجة
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+// Finished هل انتهت المهمة (بنجاح أو فشل)
+func (j *Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// JobStore يخزن المهام كملفات JSON
+type JobStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// NewJobStore ينشئ مخزن مهام في dir
+func NewJobStore(dir string) *JobStore {
+	return &JobStore{Dir: dir}
+}
+
+// DefaultJobStore مخزن المهام المشترك بين سيرفر الـ API والـ worker
+var DefaultJobStore = sync.OnceValue(func() *JobStore {
+	return NewJobStore(JobsDir)
+})
+
+// NewJobID ينشئ معرفاً عشوائياً لمهمة
+func NewJobID() (string, error) {
+	return newUploadID()
+}
+
+// Register يربط مسار حالة المهام بالـ router
+func (s *JobStore) Register(r *gin.Engine) {
+	r.GET("/jobs/:id", s.handleGet)
+}
+
+func (s *JobStore) handleGet(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	job, err := s.Get(c.Param("id"))
+	if err == nil {
+		err = authorizeJob(c, job)
+	}
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrJobOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// authorizeJob يسمح فقط لصاحب الرفع أو لمفتاح admin
+// NOTE: مهام الأحداث القديمة بدون Uploader يراها admin فقط
+func authorizeJob(c *gin.Context, job *Job) error {
+	if key := CurrentKey(c); key != nil && key.Admin {
+		return nil
+	}
+	if job.Uploader == "" || job.Uploader != Uploader(c) {
+		return ErrJobOwner
+	}
+	return nil
+}
+
+// Create يسجل المهمة بحالة queued
+// الحقول المطلوبة: ID و FileName و Path، والباقي اختياري
+// NOTE: إذا كانت المهمة موجودة تُعاد إلى queued (إعادة محاولة النشر)
+func (s *JobStore) Create(job *Job) (*Job, error) {
+	if !validJobID(job.ID) {
+		return nil, ErrJobNotFound
+	}
+
+	now := time.Now().UTC()
+	job.Status = JobQueued
+	job.Progress = 0
+	job.Error = ""
+	job.Artifacts = nil
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := s.save(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Enqueue يسجل المهمة وينشر PcapUploadedEvent للـ worker
+//
+// NOTE: عند فشل النشر تصبح المهمة failed ويرجع ErrPublishFailed،
+// والملف يبقى في مكانه حتى يعاد إرساله.
+func (s *JobStore) Enqueue(publisher *EventPublisher, job *Job) (*Job, error) {
+	job, err := s.Create(job)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.publish(publisher, job); err != nil {
+		if failed, ferr := s.Fail(job.ID, err); ferr == nil {
+			job = failed
+		}
+		return job, err
+	}
+
+	return job, nil
+}
+
+// publish ينشر PcapUploadedEvent للمهمة
+func (s *JobStore) publish(publisher *EventPublisher, job *Job) error {
+	if err := publisher.Publish(QueueName, events.PcapUploadedEvent{
+		JobID:    job.ID,
+		FileName: job.FileName,
+		Path:     job.Path,
+		Size:     job.Size,
+		Split:    job.Split,
+		Output:   job.Output,
+	}); err != nil {
+		return fmt.Errorf("%w: %v", ErrPublishFailed, err)
+	}
+	return nil
+}
+
+// RequeueRunning يعيد المهام العالقة في running إلى queued وينشرها من جديد
+//
+// NOTE: يُستدعى عند بدء الـ worker قبل استهلاك الطابور، فأي مهمة running
+// بقيت من worker توقف أثناء المعالجة (worker واحد لكل JobsDir).
+// الرسالة الأصلية قد تعود أيضاً من RabbitMQ: النسخة التي تصل بعد انتهاء
+// المهمة تُتجاهل (ErrJobFinished). عند فشل النشر تصبح المهمة failed.
+func (s *JobStore) RequeueRunning(publisher *EventPublisher) ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var requeued []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		job, err := s.update(id, func(j *Job) error {
+			if j.Status != JobRunning {
+				return errJobNotRunning
+			}
+			j.Status = JobQueued
+			j.Progress = 0
+			return nil
+		})
+		if err != nil {
+			continue
+		}
+
+		if err := s.publish(publisher, job); err != nil {
+			log.Printf("⚠️ failed to requeue job %s: %v", id, err)
+			s.Fail(id, err)
+			continue
+		}
+		requeued = append(requeued, id)
+	}
+
+	return requeued, nil
+}
+
+// SubmitUpload يحفظ الملف المرفوع في UploadsDir ويسجل مهمة معالجته
+//
+// opts استراتيجية التقسيم وصيغة الأجزاء (انظر ParseProcessOptions).
+//
+// NOTE: الملف يُحفظ باسم <job id>_<name> حتى لا يستبدل رفعٌ رفعاً آخر بنفس الاسم.
+// عند ErrPublishFailed يكون الملف محفوظاً والمهمة failed.
+func SubmitUpload(file io.Reader, fileName string, uploader string, size int64, opts ProcessOptions) (*Job, error) {
+	id, err := NewJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	// فحص أول البايتات قبل كتابة أي شيء على القرص
+	format, file, err := infra.SniffCapture(file)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName = filepath.Base(fileName)
+	path, err := SaveUpload(file, id+"_"+fileName, UploadsDir, uploader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return DefaultJobStore().Enqueue(DefaultPublisher(), &Job{
+		ID:       id,
+		FileName: fileName,
+		Path:     path,
+		Uploader: uploader,
+		Size:     info.Size(),
+		Format:   &format,
+		Split:    opts.Split.String(),
+		Output:   opts.Output,
+	})
+}
+
+// Get يقرأ مهمة
+func (s *JobStore) Get(id string) (*Job, error) {
+	if !validJobID(id) {
+		return nil, ErrJobNotFound
+	}
+
+	data, err := os.ReadFile(s.jobPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("corrupted job %s: %w", id, err)
+	}
+
+	return &job, nil
+}
+
+// Update يطبق change على المهمة ويحفظها
+func (s *JobStore) Update(id string, change func(*Job)) (*Job, error) {
+	return s.update(id, func(j *Job) error {
+		change(j)
+		return nil
+	})
+}
+
+// update مثل Update، والخطأ من change يلغي الحفظ
+func (s *JobStore) update(id string, change func(*Job) error) (*Job, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := change(job); err != nil {
+		return nil, err
+	}
+	job.UpdatedAt = time.Now().UTC()
+
+	if err := s.save(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Start ينقل المهمة إلى running
+// NOTE: المهمة المنتهية لا تبدأ مرة أخرى (ErrJobFinished)، حتى لا تُعالج
+// رسالة مكررة مرتين. إعادة الرفع تمر عبر Create الذي يعيدها إلى queued.
+func (s *JobStore) Start(id string) (*Job, error) {
+	return s.update(id, func(j *Job) error {
+		if j.Finished() {
+			return ErrJobFinished
+		}
+		j.Status = JobRunning
+		j.Progress = 0
+		j.Error = ""
+		return nil
+	})
+}
+
+// SetProgress يحدّث نسبة التقدم (0-100)
+func (s *JobStore) SetProgress(id string, percent int) (*Job, error) {
+	return s.Update(id, func(j *Job) {
+		j.Progress = max(0, min(percent, 100))
+	})
+}
+
+// Succeed ينهي المهمة بنجاح مع الملفات الناتجة
+func (s *JobStore) Succeed(id string, artifacts []string) (*Job, error) {
+	return s.Update(id, func(j *Job) {
+		j.Status = JobSucceeded
+		j.Progress = 100
+		j.Artifacts = artifacts
+	})
+}
+
+// Fail ينهي المهمة مع رسالة الخطأ
+func (s *JobStore) Fail(id string, cause error) (*Job, error) {
+	return s.Update(id, func(j *Job) {
+		j.Status = JobFailed
+		j.Error = cause.Error()
+	})
+}
+
+// Prune يحذف المهام المنتهية التي لم تتغير منذ maxAge
+func (s *JobStore) Prune(maxAge time.Duration) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		if s.pruneJob(id, maxAge) {
+			log.Printf("🗑️ تم حذف مهمة قديمة: %s", id)
+		}
+	}
+}
+
+// pruneJob يحذف المهمة إذا كانت منتهية وقديمة (الفحص تحت القفل)
+func (s *JobStore) pruneJob(id string, maxAge time.Duration) bool {
+	unlock, err := s.lock()
+	if err != nil {
+		return false
+	}
+	defer unlock()
+
+	job, err := s.Get(id)
+	if err != nil || !job.Finished() || time.Since(job.UpdatedAt) < maxAge {
+		return false
+	}
+	return os.Remove(s.jobPath(id)) == nil
+}
+
+// lock يقفل المخزن داخل العملية وبين العمليات (الـ API والـ worker)
+func (s *JobStore) lock() (func(), error) {
+	s.mu.Lock()
+
+	unlockFile, err := infra.NewLocalFileSystem().Lock(filepath.Join(s.Dir, jobsLockFile))
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *JobStore) jobPath(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// save يكتب المهمة (ملف مؤقت + rename)، المستدعي يملك القفل
+func (s *JobStore) save(job *Job) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, job.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.jobPath(job.ID))
+}
+
+// validJobID الـ id اسم ملف، نرفض أي مسار
+func validJobID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
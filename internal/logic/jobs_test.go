@@ -0,0 +1,117 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestJob(t *testing.T, s *JobStore, id string, uploader string) *Job {
+	t.Helper()
+	job, err := s.Create(&Job{ID: id, FileName: "cap.pcap", Path: "/data/uploads/cap.pcap", Uploader: uploader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := NewJobStore(t.TempDir())
+	newTestJob(t, s, "owned", "owner")
+	newTestJob(t, s, "legacy", "")
+
+	tests := []struct {
+		name string
+		key  *infra.APIKey
+		id   string
+		want int
+	}{
+		{"owner", &infra.APIKey{ID: "owner"}, "owned", http.StatusOK},
+		{"admin", &infra.APIKey{ID: "root", Admin: true}, "owned", http.StatusOK},
+		{"other key", &infra.APIKey{ID: "other"}, "owned", http.StatusForbidden},
+		{"legacy job", &infra.APIKey{ID: "owner"}, "legacy", http.StatusForbidden},
+		{"legacy job admin", &infra.APIKey{ID: "root", Admin: true}, "legacy", http.StatusOK},
+		{"unknown id", &infra.APIKey{ID: "owner"}, "missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(withKey(tt.key))
+			s.Register(r)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+tt.id, nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobStartFinished(t *testing.T) {
+	s := NewJobStore(t.TempDir())
+	newTestJob(t, s, "job", "owner")
+
+	if _, err := s.Succeed("job", []string{"chunk_0.pcap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// رسالة مكررة لمهمة منتهية لا تعيد تشغيلها
+	if _, err := s.Start("job"); !errors.Is(err, ErrJobFinished) {
+		t.Fatalf("got %v, want ErrJobFinished", err)
+	}
+	job, err := s.Get("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JobSucceeded || len(job.Artifacts) != 1 {
+		t.Fatalf("finished job changed: %+v", job)
+	}
+
+	// إعادة الرفع تعيد المهمة إلى queued
+	newTestJob(t, s, "job", "owner")
+	if _, err := s.Start("job"); err != nil {
+		t.Fatalf("resubmitted job not started: %v", err)
+	}
+}
+
+func TestJobRequeueRunning(t *testing.T) {
+	s := NewJobStore(t.TempDir())
+	newTestJob(t, s, "queued", "owner")
+	newTestJob(t, s, "running", "owner")
+	newTestJob(t, s, "done", "owner")
+
+	if _, err := s.Start("running"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Succeed("done", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// بدون publisher يفشل النشر: المهمة العالقة تصبح failed بدلاً من running للأبد
+	requeued, err := s.RequeueRunning(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requeued) != 0 {
+		t.Fatalf("requeued %v without a publisher", requeued)
+	}
+
+	want := map[string]JobStatus{"queued": JobQueued, "running": JobFailed, "done": JobSucceeded}
+	for id, status := range want {
+		job, err := s.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != status {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+	}
+}
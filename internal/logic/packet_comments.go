@@ -0,0 +1,233 @@
+package logic
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/gopacket"
+)
+
+// تعليقات الحزم في pcapng (option رقم 1 في EPB)
+//
+// pcapgo v1.1.19 يتجاهل options الحزم في القراءة والكتابة، لذلك:
+//   - القراءة: commentScanner يرى نفس البايتات التي يقرأها NgReader
+//     ويحفظ تعليقات كل حزمة بالترتيب، ثم يضيفها ngSource إلى ci.AncillaryData
+//   - الكتابة: ngChunkWriter يكتب EPB بنفسه مع التعليقات (appendEnhancedPacket)
+//
+// إخراج pcap لا يحمل تعليقات، فتضيع فيه
+
+const (
+	ngBlockSectionHeader  = 0x0A0D0D0A
+	ngBlockPacket         = 2 // الصيغة القديمة، نفس مكان options في EPB
+	ngBlockSimplePacket   = 3 // بدون options
+	ngBlockEnhancedPacket = 6
+
+	ngByteOrderMagic = 0x1A2B3C4D
+
+	ngOptionEnd     = 0
+	ngOptionComment = 1
+)
+
+// packetComments تعليقات حزمة واحدة في ci.AncillaryData
+type packetComments []string
+
+// errCommentsOutOfSync الـ scanner لم يرَ نفس الحزم التي أرجعها NgReader،
+// فلا يمكن ربط التعليقات بحزمها
+var errCommentsOutOfSync = errors.New("pcapng packet comments out of sync")
+
+// commentsOf يرجع تعليقات الحزمة (nil إذا لم توجد)
+func commentsOf(ci gopacket.CaptureInfo) []string {
+	for _, data := range ci.AncillaryData {
+		if comments, ok := data.(packetComments); ok {
+			return comments
+		}
+	}
+	return nil
+}
+
+/*
+========================
+READING
+========================
+*/
+
+// commentScanner يُستخدم مع io.TeeReader أمام NgReader
+// NOTE: NgReader يقرأ مسبقاً (bufio)، فقد يسبق الـ scanner الحزمة الحالية،
+// لذلك التعليقات في طابور وتُسحب حزمة بحزمة في next
+type commentScanner struct {
+	pending []byte
+	order   binary.ByteOrder
+	skip    bool // section بإصدار غير مدعوم، NgReader يتخطى حزمها (SkipUnknownVersion)
+	queue   [][]string
+	packets int // عدد الحزم التي رآها، يُقارن بما أرجعه NgReader
+	failed  bool
+}
+
+// Write لا يرجع خطأ أبداً: الملف التالف يرفضه NgReader نفسه،
+// وأي اختلاف عنه يظهر في ngSource (errCommentsOutOfSync)
+func (s *commentScanner) Write(p []byte) (int, error) {
+	if s.failed {
+		return len(p), nil
+	}
+
+	s.pending = append(s.pending, p...)
+	used := 0
+	for {
+		n, ok := s.block(s.pending[used:])
+		if !ok {
+			break
+		}
+		used += n
+	}
+	s.pending = append(s.pending[:0], s.pending[used:]...)
+	return len(p), nil
+}
+
+// next تعليقات الحزمة التالية التي أرجعها NgReader
+// false إذا فشل الـ scanner أو لم يرَ هذه الحزمة
+func (s *commentScanner) next() ([]string, bool) {
+	if s.failed || len(s.queue) == 0 {
+		return nil, false
+	}
+	comments := s.queue[0]
+	s.queue = s.queue[1:]
+	return comments, true
+}
+
+// block يقرأ block كاملاً من بداية p، false إذا لم يكتمل بعد
+func (s *commentScanner) block(p []byte) (int, bool) {
+	if len(p) < 12 {
+		return 0, false
+	}
+
+	// رقم الـ SHB متماثل، وترتيب البايتات يُعرف من الـ magic بعد الطول
+	if binary.LittleEndian.Uint32(p[0:4]) == ngBlockSectionHeader {
+		switch {
+		case binary.LittleEndian.Uint32(p[8:12]) == ngByteOrderMagic:
+			s.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(p[8:12]) == ngByteOrderMagic:
+			s.order = binary.BigEndian
+		default:
+			s.failed = true
+			return 0, false
+		}
+	}
+	if s.order == nil {
+		s.failed = true
+		return 0, false
+	}
+
+	length := int(s.order.Uint32(p[4:8]))
+	if length < 12 || length%4 != 0 {
+		s.failed = true
+		return 0, false
+	}
+	if len(p) < length {
+		return 0, false
+	}
+	block := p[:length]
+
+	switch s.order.Uint32(block[0:4]) {
+	case ngBlockSectionHeader:
+		if length < 16 {
+			s.failed = true
+			return 0, false
+		}
+		s.skip = s.order.Uint16(block[12:14]) != 1 || s.order.Uint16(block[14:16]) != 0
+	case ngBlockEnhancedPacket, ngBlockPacket:
+		if !s.skip {
+			s.queue = append(s.queue, s.comments(block))
+			s.packets++
+		}
+	case ngBlockSimplePacket:
+		if !s.skip {
+			s.queue = append(s.queue, nil)
+			s.packets++
+		}
+	}
+	return length, true
+}
+
+// comments يقرأ options الحزمة (بعد البيانات) في EPB أو PB
+func (s *commentScanner) comments(block []byte) []string {
+	if len(block) < 32 {
+		return nil
+	}
+
+	var comments []string
+	end := len(block) - 4
+	offset := 28 + pad4(int(s.order.Uint32(block[20:24])))
+	for offset+4 <= end {
+		code := s.order.Uint16(block[offset : offset+2])
+		length := int(s.order.Uint16(block[offset+2 : offset+4]))
+		value := offset + 4
+		if code == ngOptionEnd || value+length > end {
+			break
+		}
+		if code == ngOptionComment {
+			comments = append(comments, string(block[value:value+length]))
+		}
+		offset = value + pad4(length)
+	}
+	return comments
+}
+
+/*
+========================
+WRITING
+========================
+*/
+
+// enhancedPacketLength طول EPB كما يكتبه appendEnhancedPacket
+func enhancedPacketLength(captureLength int, comments []string) int {
+	// النوع والطول والواجهة والتوقيت و caplen و length (28)،
+	// البيانات حتى مضاعف 4، والطول مرة أخرى في النهاية (4)
+	length := 32 + pad4(captureLength)
+	if len(comments) > 0 {
+		for _, comment := range comments {
+			length += 4 + pad4(len(comment))
+		}
+		length += 4 // نهاية الـ options
+	}
+	return length
+}
+
+// appendEnhancedPacket يضيف EPB للحزمة مع تعليقاتها إلى buf
+// NOTE: مثل pcapgo.NgWriter: little-endian والتوقيت بالنانوثانية
+// (الواجهات تُكتب بـ tsresol 9)
+func appendEnhancedPacket(buf []byte, ci gopacket.CaptureInfo, data []byte, comments []string) []byte {
+	le := binary.LittleEndian
+	length := uint32(enhancedPacketLength(len(data), comments))
+	ts := uint64(ci.Timestamp.UnixNano())
+
+	buf = le.AppendUint32(buf, ngBlockEnhancedPacket)
+	buf = le.AppendUint32(buf, length)
+	buf = le.AppendUint32(buf, uint32(ci.InterfaceIndex))
+	buf = le.AppendUint32(buf, uint32(ts>>32))
+	buf = le.AppendUint32(buf, uint32(ts))
+	buf = le.AppendUint32(buf, uint32(ci.CaptureLength))
+	buf = le.AppendUint32(buf, uint32(ci.Length))
+	buf = appendPadded(buf, data)
+
+	if len(comments) > 0 {
+		for _, comment := range comments {
+			buf = le.AppendUint16(buf, ngOptionComment)
+			buf = le.AppendUint16(buf, uint16(len(comment)))
+			buf = appendPadded(buf, []byte(comment))
+		}
+		buf = le.AppendUint32(buf, ngOptionEnd)
+	}
+
+	return le.AppendUint32(buf, length)
+}
+
+// appendPadded يضيف data مع أصفار حتى مضاعف 4
+func appendPadded(buf []byte, data []byte) []byte {
+	buf = append(buf, data...)
+	return append(buf, make([]byte, pad4(len(data))-len(data))...)
+}
+
+// pad4 الطول بعد التقريب إلى مضاعف 4
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,268 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestPacketCommentsSurviveSplit(t *testing.T) {
+	want := [][]string{{"first"}, nil, {"a", "bc"}, {"last"}}
+
+	// pcapgo لا يكتب تعليقات الحزم: الـ section والواجهة منه والحزم يدوياً
+	var buf bytes.Buffer
+	w, err := pcapgo.NewNgWriterInterface(&buf, pcapgo.NgInterface{LinkType: layers.LinkTypeEthernet, SnapLength: 65535}, pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var packets []byte
+	for i, comments := range want {
+		data := make([]byte, 61)
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 5), CaptureLength: len(data), Length: len(data)}
+		packets = appendEnhancedPacket(packets, ci, data, comments)
+	}
+	buf.Write(packets)
+
+	fs := infra.NewMemFileSystem()
+	names, err := ProcessPcapWith(fs, &buf, "cap.pcapng", ProcessOptions{Split: SplitSpec{Strategy: SplitCount, Packets: 3}, Output: OutputPcapNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(names))
+	}
+
+	var got [][]string
+	for _, name := range names {
+		data, err := fs.ReadFile(filepath.Join(OutputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := openCaptureSource(infra.CaptureFormat{Container: infra.FormatPcapNG}, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, ci, err := source.ReadPacketData()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got = append(got, commentsOf(ci))
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("comments %q, want %q", got, want)
+	}
+}
+
+func TestCommentScannerBigEndian(t *testing.T) {
+	be := binary.BigEndian
+
+	// SHB بدون options
+	var capture []byte
+	capture = be.AppendUint32(capture, ngBlockSectionHeader)
+	capture = be.AppendUint32(capture, 28)
+	capture = be.AppendUint32(capture, ngByteOrderMagic)
+	capture = be.AppendUint16(capture, 1)
+	capture = be.AppendUint16(capture, 0)
+	capture = be.AppendUint64(capture, ^uint64(0))
+	capture = be.AppendUint32(capture, 28)
+
+	// EPB ببيانات 3 بايت وتعليق "hi"
+	capture = be.AppendUint32(capture, ngBlockEnhancedPacket)
+	capture = be.AppendUint32(capture, 44)
+	capture = append(capture, make([]byte, 12)...) // الواجهة والتوقيت
+	capture = be.AppendUint32(capture, 3)
+	capture = be.AppendUint32(capture, 3)
+	capture = append(capture, 1, 2, 3, 0)
+	capture = be.AppendUint16(capture, ngOptionComment)
+	capture = be.AppendUint16(capture, 2)
+	capture = append(capture, 'h', 'i', 0, 0)
+	capture = be.AppendUint32(capture, ngOptionEnd)
+	capture = be.AppendUint32(capture, 44)
+
+	// بايت بعد بايت: الـ block يكتمل على عدة Write
+	s := &commentScanner{}
+	for i := range capture {
+		s.Write(capture[i : i+1])
+	}
+
+	if got, ok := s.next(); !ok || !reflect.DeepEqual(got, []string{"hi"}) {
+		t.Fatalf("got %q, want [hi]", got)
+	}
+	if s.failed || len(s.queue) != 0 {
+		t.Fatalf("failed %t, %d comments left", s.failed, len(s.queue))
+	}
+}
+
+// ngPacket حزمة pcapng كما تُكتب في الملف
+type ngPacket struct {
+	block    uint32 // ngBlockEnhancedPacket أو ngBlockPacket أو ngBlockSimplePacket
+	comments []string
+}
+
+// ngSection section كامل little-endian: SHB وواجهة Ethernet (tsresol 9) والحزم
+// NOTE: PB مثل EPB مع رقم واجهة 16 بت و drops 16 بت (الاثنان صفر هنا)
+func ngSection(packets []ngPacket, first int) []byte {
+	le := binary.LittleEndian
+
+	var b []byte
+	b = le.AppendUint32(b, ngBlockSectionHeader)
+	b = le.AppendUint32(b, 28)
+	b = le.AppendUint32(b, ngByteOrderMagic)
+	b = le.AppendUint16(b, 1)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint64(b, ^uint64(0))
+	b = le.AppendUint32(b, 28)
+
+	// IDB مع if_tsresol (option 9) = 9
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint32(b, 32)
+	b = le.AppendUint16(b, uint16(layers.LinkTypeEthernet))
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint32(b, 65535)
+	b = le.AppendUint16(b, 9)
+	b = le.AppendUint16(b, 1)
+	b = append(b, 9, 0, 0, 0)
+	b = le.AppendUint32(b, ngOptionEnd)
+	b = le.AppendUint32(b, 32)
+
+	for i, packet := range packets {
+		data := bytes.Repeat([]byte{byte(first + i)}, 61)
+		switch packet.block {
+		case ngBlockSimplePacket:
+			length := uint32(16 + pad4(len(data)))
+			b = le.AppendUint32(b, ngBlockSimplePacket)
+			b = le.AppendUint32(b, length)
+			b = le.AppendUint32(b, uint32(len(data)))
+			b = appendPadded(b, data)
+			b = le.AppendUint32(b, length)
+		default:
+			ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(first+i), 5), CaptureLength: len(data), Length: len(data)}
+			start := len(b)
+			b = appendEnhancedPacket(b, ci, data, packet.comments)
+			le.PutUint32(b[start:], packet.block)
+		}
+	}
+	return b
+}
+
+func TestPacketCommentsAcrossSections(t *testing.T) {
+	first := []ngPacket{
+		{ngBlockEnhancedPacket, []string{"a"}},
+		{ngBlockPacket, []string{"pb"}},
+		{ngBlockSimplePacket, nil},
+	}
+	second := []ngPacket{
+		{ngBlockEnhancedPacket, nil},
+		{ngBlockEnhancedPacket, []string{"x", "y"}},
+		{ngBlockSimplePacket, nil},
+		{ngBlockPacket, nil},
+	}
+	capture := append(ngSection(first, 0), ngSection(second, len(first))...)
+
+	var want [][]string
+	for _, packet := range append(first, second...) {
+		want = append(want, packet.comments)
+	}
+
+	// المصدر نفسه: تعليق كل حزمة مع حزمتها
+	got, data := readComments(t, capture)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("source comments %q, want %q", got, want)
+	}
+	for i, packet := range data {
+		if packet[0] != byte(i) {
+			t.Fatalf("packet %d has data of packet %d", i, packet[0])
+		}
+	}
+
+	// وبعد التقسيم إلى pcapng
+	fs := infra.NewMemFileSystem()
+	names, err := ProcessPcapWith(fs, bytes.NewReader(capture), "cap.pcapng", ProcessOptions{Split: SplitSpec{Strategy: SplitCount, Packets: 3}, Output: OutputPcapNG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, name := range names {
+		chunk, err := fs.ReadFile(filepath.Join(OutputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		comments, _ := readComments(t, chunk)
+		got = append(got, comments...)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("chunk comments %q, want %q", got, want)
+	}
+}
+
+// readComments يقرأ كل الحزم ويرجع تعليقاتها وبياناتها بالترتيب
+func readComments(t *testing.T, capture []byte) ([][]string, [][]byte) {
+	t.Helper()
+
+	source, err := openCaptureSource(infra.CaptureFormat{Container: infra.FormatPcapNG}, bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comments [][]string
+	var data [][]byte
+	for {
+		packet, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			return comments, data
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		comments = append(comments, commentsOf(ci))
+		data = append(data, packet)
+	}
+}
+
+func TestCommentsOutOfSync(t *testing.T) {
+	capture := ngSection([]ngPacket{{ngBlockEnhancedPacket, []string{"a"}}}, 0)
+
+	// scanner لم يرَ الحزمة (مثلاً فشل في منتصف الملف)
+	reader, err := pcapgo.NewNgReader(bytes.NewReader(capture), pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &ngSource{NgReader: reader, comments: &commentScanner{}}
+	if _, _, err := source.ReadPacketData(); !errors.Is(err, errCommentsOutOfSync) {
+		t.Fatalf("got %v, want errCommentsOutOfSync", err)
+	}
+
+	// scanner رأى حزمة أكثر من NgReader
+	reader, err = pcapgo.NewNgReader(bytes.NewReader(capture), pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := &commentScanner{}
+	scanner.Write(capture)
+	scanner.Write(capture)
+	source = &ngSource{NgReader: reader, comments: scanner}
+	if _, _, err := source.ReadPacketData(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := source.ReadPacketData(); !errors.Is(err, errCommentsOutOfSync) {
+		t.Fatalf("got %v at the end, want errCommentsOutOfSync", err)
+	}
+}
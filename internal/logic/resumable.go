@@ -0,0 +1,637 @@
+package logic
+
+import (
+	"LM-Gate/internal/events"
+	"LM-Gate/internal/infra"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// رفع قابل للاستكمال عبر HTTP (مجموعة فرعية من بروتوكول tus 1.0.0)
+//
+//	POST   /uploads       إنشاء رفع (Upload-Length, Upload-Metadata)  → 201 + Location
+//	HEAD   /uploads/:id   معرفة الـ offset الحالي                      → Upload-Offset
+//	PATCH  /uploads/:id   إرسال بيانات من الـ offset الحالي            → 204 + Upload-Offset
+//	DELETE /uploads/:id   إلغاء الرفع وحذف بياناته
+//
+// NOTE: الحالة تُخزّن مثل الـ chunk manifest: <ResumableDir>/<id>/upload.json
+// والبيانات في <ResumableDir>/<id>/data. الـ offset هو حجم ملف البيانات،
+// لذلك يبقى صحيحاً حتى بعد انقطاع الاتصال أو إعادة تشغيل السيرفر.
+// HEAD و PATCH و DELETE مسموحة فقط لصاحب الرفع أو مفتاح admin (403).
+
+const (
+	tusVersion = "1.0.0"
+
+	// ResumableDir مجلد حالة الرفع القابل للاستكمال (داخل الـ volume المشترك)
+	ResumableDir = UploadsDir + "/temp_chunks"
+
+	uploadStateFile = "upload.json"
+	uploadDataFile  = "data"
+	uploadLockFile  = "upload.lock" // يأخذه الـ Janitor قبل حذف رفع منتهي
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadBusy     = errors.New("upload is being written by another request")
+	ErrPublishFailed  = errors.New("upload stored but event not published")
+	ErrUploadOwner    = errors.New("upload belongs to another API key")
+)
+
+// UploadState حالة رفع واحد
+type UploadState struct {
+	ID        string               `json:"id"`
+	Length    int64                `json:"length"`
+	FileName  string               `json:"file_name"`
+	Uploader  string               `json:"uploader"`
+	CreatedAt time.Time            `json:"created_at"`
+	Path      string               `json:"path,omitempty"`   // المسار النهائي بعد الاكتمال
+	Published bool                 `json:"published"`        // هل أُرسل PcapUploadedEvent
+	Format    *infra.CaptureFormat `json:"format,omitempty"` // من أول PATCH
+	Split     string               `json:"split,omitempty"`  // من Upload-Metadata split
+	Output    string               `json:"output,omitempty"` // من Upload-Metadata output
+}
+
+// ResumableUploads يدير الرفع القابل للاستكمال
+type ResumableUploads struct {
+	Dir       string // مجلد الحالة والبيانات
+	UploadDir string // مجلد الملفات المكتملة
+	MaxSize   int64  // 0 = بدون حد
+	Quota     *infra.DiskQuota
+	Publisher *EventPublisher
+	Jobs      *JobStore // اختياري: تسجيل مهمة المعالجة عند الاكتمال
+
+	locks sync.Map // id -> *sync.Mutex
+}
+
+// NewResumableUploads ينشئ مدير الرفع
+func NewResumableUploads(dir string, uploadDir string, publisher *EventPublisher) *ResumableUploads {
+	return &ResumableUploads{
+		Dir:       dir,
+		UploadDir: uploadDir,
+		Publisher: publisher,
+	}
+}
+
+// Register يربط مسارات الرفع بالـ router
+func (u *ResumableUploads) Register(r *gin.Engine) {
+	r.OPTIONS("/uploads", u.handleOptions)
+	r.POST("/uploads", u.handleCreate)
+	r.HEAD("/uploads/:id", u.handleHead)
+	r.PATCH("/uploads/:id", u.handlePatch)
+	r.DELETE("/uploads/:id", u.handleDelete)
+}
+
+/*
+========================
+HTTP HANDLERS
+========================
+*/
+
+func (u *ResumableUploads) handleOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination")
+	if u.MaxSize > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(u.MaxSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (u *ResumableUploads) handleCreate(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length مطلوب ويجب أن يكون أكبر من صفر"})
+		return
+	}
+
+	if u.MaxSize > 0 && length > u.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": infra.ErrUploadTooLarge.Error()})
+		return
+	}
+
+	meta := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+
+	// اختياري: Upload-Metadata: split <base64 "time:60s">,output <base64 "pcapng">
+	opts, err := ParseProcessOptions(meta["split"], meta["output"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err))
+		return
+	}
+
+	state, err := u.Create(length, meta["filename"], Uploader(c), opts)
+	if errors.Is(err, infra.ErrQuotaExceeded) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/uploads/"+state.ID)
+	c.Header("Upload-Offset", "0")
+	c.Status(http.StatusCreated)
+}
+
+func (u *ResumableUploads) handleHead(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+
+	if err := u.authorize(c, c.Param("id")); err != nil {
+		c.Status(uploadErrorStatus(err))
+		return
+	}
+
+	state, offset, err := u.Status(c.Param("id"))
+	if err != nil {
+		c.Status(uploadErrorStatus(err))
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(state.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+func (u *ResumableUploads) handlePatch(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type يجب أن يكون application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset غير صالح"})
+		return
+	}
+
+	if err := u.authorize(c, c.Param("id")); err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	newOffset, err := u.Write(c.Param("id"), offset, c.Request.Body)
+	if newOffset > 0 {
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	}
+	if err != nil {
+		log.Printf("⚠️ resumable upload %s: %v", c.Param("id"), err)
+		c.JSON(uploadErrorStatus(err), errorBody(err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (u *ResumableUploads) handleDelete(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if err := u.authorize(c, c.Param("id")); err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := u.Delete(c.Param("id")); err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// authorize يسمح فقط لصاحب الرفع أو لمفتاح admin
+func (u *ResumableUploads) authorize(c *gin.Context, id string) error {
+	state, err := u.loadState(id)
+	if err != nil {
+		return err
+	}
+
+	if key := CurrentKey(c); key != nil && key.Admin {
+		return nil
+	}
+	if state.Uploader != Uploader(c) {
+		return ErrUploadOwner
+	}
+	return nil
+}
+
+// uploadErrorStatus يحوّل الأخطاء إلى رموز HTTP
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUploadOwner):
+		return http.StatusForbidden
+	case errors.Is(err, ErrOffsetMismatch), errors.Is(err, ErrUploadBusy):
+		return http.StatusConflict
+	case errors.Is(err, infra.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, infra.ErrInvalidCapture):
+		return infra.CaptureErrorStatus(err)
+	case errors.Is(err, infra.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrPublishFailed):
+		// البيانات محفوظة، PATCH فارغ لاحقاً يعيد محاولة النشر
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+/*
+========================
+UPLOAD LOGIC
+========================
+*/
+
+// Create يبدأ رفعاً جديداً ويحجز حجمه الكامل في حصة القرص
+func (u *ResumableUploads) Create(length int64, fileName string, uploader string, opts ProcessOptions) (*UploadState, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	if fileName = filepath.Base(fileName); fileName == "." || fileName == "/" {
+		fileName = id + ".pcap"
+	}
+
+	state := &UploadState{
+		ID:        id,
+		Length:    length,
+		FileName:  fileName,
+		Uploader:  uploader,
+		CreatedAt: time.Now().UTC(),
+		Split:     opts.Split.String(),
+		Output:    opts.Output,
+	}
+
+	if err := os.MkdirAll(u.uploadDir(id), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := u.Quota.Reserve(uploader, u.dataPath(id), length); err != nil {
+		os.RemoveAll(u.uploadDir(id))
+		return nil, err
+	}
+
+	if err := os.WriteFile(u.dataPath(id), nil, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := u.saveState(state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Status يرجع الحالة والـ offset الحالي (حجم البيانات المستلمة)
+func (u *ResumableUploads) Status(id string) (*UploadState, int64, error) {
+	state, err := u.loadState(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if state.Path != "" {
+		return state, state.Length, nil
+	}
+
+	info, err := os.Stat(u.dataPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return state, info.Size(), nil
+}
+
+// Write يضيف البيانات عند الـ offset ويرجع الـ offset الجديد
+//
+// NOTE: ما وصل قبل انقطاع الاتصال يبقى محفوظاً، والعميل يكمل من HEAD.
+// أول البايتات تحدد الصيغة: PATCH أقصر من الـ header يُحفظ وينتظر التالي.
+// عند الوصول إلى Upload-Length ينقل الملف إلى UploadDir وينشر PcapUploadedEvent.
+// PATCH فارغ على رفع مكتمل يعيد محاولة النشر إذا فشلت سابقاً.
+func (u *ResumableUploads) Write(id string, offset int64, body io.Reader) (int64, error) {
+	unlock, err := u.lock(id)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	state, current, err := u.Status(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if offset != current {
+		return current, fmt.Errorf("%w: got %d, have %d", ErrOffsetMismatch, offset, current)
+	}
+
+	if state.Path == "" && current < state.Length {
+		// أول البايتات تحدد صيغة الملف، نرفض قبل كتابة الباقي
+		if state.Format == nil {
+			if body, err = u.sniff(state, current, body); err != nil {
+				return current, err
+			}
+		}
+
+		current, err = u.appendData(id, current, state.Length, body)
+		if err != nil {
+			return current, err
+		}
+	}
+
+	if current < state.Length {
+		return current, nil
+	}
+
+	return current, u.finish(state)
+}
+
+// sniff يحدد صيغة الملف من البايتات المحفوظة + بداية body
+//
+// NOTE: إذا انتهى body قبل اكتمال الـ header تُرجع البايتات كما هي لتُحفظ،
+// والصيغة تُحدد في PATCH التالي (بدلاً من رفضها كـ truncated_header)
+func (u *ResumableUploads) sniff(state *UploadState, current int64, body io.Reader) (io.Reader, error) {
+	stored, err := readHead(u.dataPath(state.ID), min(current, infra.SniffSize))
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, max(min(infra.SniffSize-current, state.Length-current), 0))
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	format, err := infra.DetectCaptureFormat(append(stored, head...))
+	if err != nil {
+		// الـ header لم يكتمل بعد والرفع ما زال مستمراً
+		waiting := current+int64(n) < min(infra.SniffSize, state.Length)
+		code := infra.CaptureErrorCode(err)
+		if waiting && (code == infra.CodeTruncatedHeader || code == infra.CodeEmptyUpload) {
+			return bytes.NewReader(head), nil
+		}
+		return nil, err
+	}
+
+	state.Format = &format
+	if err := u.saveState(state); err != nil {
+		return nil, err
+	}
+
+	return io.MultiReader(bytes.NewReader(head), body), nil
+}
+
+// Delete يلغي الرفع ويحذف بياناته
+func (u *ResumableUploads) Delete(id string) error {
+	unlock, err := u.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := u.loadState(id); err != nil {
+		return err
+	}
+
+	dir := u.uploadDir(id)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	u.Quota.Release(dir)
+	u.locks.Delete(id)
+
+	return nil
+}
+
+// appendData يكتب من body حتى Upload-Length كحد أقصى
+func (u *ResumableUploads) appendData(id string, offset int64, length int64, body io.Reader) (int64, error) {
+	file, err := os.OpenFile(u.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+
+	// بايت إضافي لكشف إرسال أكثر من Upload-Length
+	written, err := io.Copy(file, io.LimitReader(body, length-offset+1))
+	if written > length-offset {
+		file.Truncate(length)
+		return length, fmt.Errorf("%w: body exceeds Upload-Length", infra.ErrUploadTooLarge)
+	}
+
+	offset += written
+	if err != nil {
+		// انقطاع الاتصال: البيانات المكتوبة تبقى والعميل يكمل لاحقاً
+		return offset, fmt.Errorf("upload interrupted at %d: %w", offset, err)
+	}
+
+	return offset, file.Sync()
+}
+
+// finish ينقل الملف المكتمل وينشر الحدث للـ worker
+func (u *ResumableUploads) finish(state *UploadState) error {
+	if state.Path == "" {
+		if err := os.MkdirAll(u.UploadDir, 0755); err != nil {
+			return err
+		}
+
+		finalPath := filepath.Join(u.UploadDir, state.ID+"_"+state.FileName)
+		if err := os.Rename(u.dataPath(state.ID), finalPath); err != nil {
+			return err
+		}
+
+		// الحجز ينتقل من ملف البيانات المؤقت إلى الملف النهائي
+		u.Quota.Release(u.dataPath(state.ID))
+		if err := u.Quota.Reserve(state.Uploader, finalPath, state.Length); err != nil {
+			log.Printf("⚠️ quota after resumable upload %s: %v", state.ID, err)
+		}
+
+		state.Path = finalPath
+		if err := u.saveState(state); err != nil {
+			return err
+		}
+	}
+
+	if state.Published {
+		return nil
+	}
+
+	// رقم المهمة هو نفس رقم الرفع: GET /jobs/<id>
+	if u.Jobs != nil {
+		if _, err := u.Jobs.Enqueue(u.Publisher, &Job{
+			ID:       state.ID,
+			FileName: state.FileName,
+			Path:     state.Path,
+			Uploader: state.Uploader,
+			Size:     state.Length,
+			Format:   state.Format,
+			Split:    state.Split,
+			Output:   state.Output,
+		}); err != nil {
+			return err
+		}
+	} else if err := u.Publisher.Publish(QueueName, events.PcapUploadedEvent{
+		FileName: state.FileName,
+		Path:     state.Path,
+		Size:     state.Length,
+	}); err != nil {
+		return fmt.Errorf("%w: %v", ErrPublishFailed, err)
+	}
+
+	state.Published = true
+	log.Printf("📦 resumable upload completed: %s", state.Path)
+	return u.saveState(state)
+}
+
+/*
+========================
+STATE HELPERS
+========================
+*/
+
+func (u *ResumableUploads) uploadDir(id string) string {
+	return filepath.Join(u.Dir, id)
+}
+
+func (u *ResumableUploads) statePath(id string) string {
+	return filepath.Join(u.uploadDir(id), uploadStateFile)
+}
+
+func (u *ResumableUploads) dataPath(id string) string {
+	return filepath.Join(u.uploadDir(id), uploadDataFile)
+}
+
+func (u *ResumableUploads) loadState(id string) (*UploadState, error) {
+	// الـ id اسم مجلد، نرفض أي مسار
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(u.statePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state UploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("corrupted upload state %s: %w", id, err)
+	}
+
+	return &state, nil
+}
+
+// saveState يكتب الحالة (ملف مؤقت + rename)
+func (u *ResumableUploads) saveState(state *UploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := u.statePath(state.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, u.statePath(state.ID))
+}
+
+// lock يمنع طلبين متزامنين على نفس الرفع، ويمنع الـ Janitor (عملية أخرى)
+// من حذف الرفع أثناء الكتابة
+//
+// NOTE: الحالة تُقرأ قبل القفل حتى لا يُنشئ ملف القفل مجلداً لرفع غير موجود،
+// والمستدعي يقرأها مرة أخرى تحت القفل
+func (u *ResumableUploads) lock(id string) (func(), error) {
+	if _, err := u.loadState(id); err != nil {
+		return nil, err
+	}
+
+	unlock, ok := u.tryLock(id)
+	if !ok {
+		return nil, ErrUploadBusy
+	}
+
+	unlockFile, err := infra.NewLocalFileSystem().Lock(filepath.Join(u.uploadDir(id), uploadLockFile))
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile()
+		unlock()
+	}, nil
+}
+
+// tryLock يمنع طلبين PATCH متزامنين على نفس الرفع
+func (u *ResumableUploads) tryLock(id string) (func(), bool) {
+	v, _ := u.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+// readHead يقرأ أول n بايت من الملف
+func readHead(path string, n int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, n)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseUploadMetadata يقرأ Upload-Metadata: "key base64,key2 base64"
+func parseUploadMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(value)
+	}
+	return meta
+}
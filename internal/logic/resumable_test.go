@@ -0,0 +1,148 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pcapHeader ملف pcap صالح: global header بدون حزم
+var pcapHeader = []byte{
+	0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0xff, 0xff, 0, 0, 1, 0, 0, 0,
+}
+
+func newTestUploads(t *testing.T) *ResumableUploads {
+	t.Helper()
+	return NewResumableUploads(t.TempDir(), t.TempDir(), nil)
+}
+
+// withKey يضع المفتاح في الـ context كما يفعل RequireAPIKey
+func withKey(key *infra.APIKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+func TestResumableOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	u := newTestUploads(t)
+
+	state, err := u.Create(100, "cap.pcap", "owner", DefaultProcessOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    *infra.APIKey
+		method string
+		id     string
+		want   int
+	}{
+		{"owner head", &infra.APIKey{ID: "owner"}, http.MethodHead, state.ID, http.StatusOK},
+		{"admin head", &infra.APIKey{ID: "root", Admin: true}, http.MethodHead, state.ID, http.StatusOK},
+		{"other head", &infra.APIKey{ID: "other"}, http.MethodHead, state.ID, http.StatusForbidden},
+		{"other patch", &infra.APIKey{ID: "other"}, http.MethodPatch, state.ID, http.StatusForbidden},
+		{"other delete", &infra.APIKey{ID: "other"}, http.MethodDelete, state.ID, http.StatusForbidden},
+		{"unknown id", &infra.APIKey{ID: "owner"}, http.MethodHead, "missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(withKey(tt.key))
+			u.Register(r)
+
+			req := httptest.NewRequest(tt.method, "/uploads/"+tt.id, bytes.NewReader(pcapHeader))
+			req.Header.Set("Upload-Offset", "0")
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// الرفع ما زال موجوداً بعد محاولة الحذف المرفوضة
+	if _, _, err := u.Status(state.ID); err != nil {
+		t.Fatalf("upload removed by another key: %v", err)
+	}
+}
+
+func TestResumableShortFirstPatch(t *testing.T) {
+	u := newTestUploads(t)
+
+	state, err := u.Create(100, "cap.pcap", "owner", DefaultProcessOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// أقل من الـ header: يُحفظ بدون رفض
+	offset, err := u.Write(state.ID, 0, bytes.NewReader(pcapHeader[:10]))
+	if err != nil {
+		t.Fatalf("short first PATCH rejected: %v", err)
+	}
+	if offset != 10 {
+		t.Fatalf("offset %d, want 10", offset)
+	}
+
+	offset, err = u.Write(state.ID, 10, bytes.NewReader(pcapHeader[10:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != int64(len(pcapHeader)) {
+		t.Fatalf("offset %d, want %d", offset, len(pcapHeader))
+	}
+
+	saved, err := u.loadState(state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Format == nil || saved.Format.Container != infra.FormatPcap {
+		t.Fatalf("format not detected after the header completed: %+v", saved.Format)
+	}
+}
+
+func TestResumableRejectsBadFormat(t *testing.T) {
+	u := newTestUploads(t)
+
+	state, err := u.Create(100, "cap.txt", "owner", DefaultProcessOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset, err := u.Write(state.ID, 0, bytes.NewReader(bytes.Repeat([]byte("text"), 8)))
+	if !errors.Is(err, infra.ErrInvalidCapture) {
+		t.Fatalf("got %v, want ErrInvalidCapture", err)
+	}
+	if offset != 0 {
+		t.Fatalf("rejected bytes were stored, offset %d", offset)
+	}
+}
+
+func TestResumableUnknownUploadCreatesNothing(t *testing.T) {
+	u := newTestUploads(t)
+
+	if _, err := u.Write("missing", 0, bytes.NewReader(pcapHeader)); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("got %v, want ErrUploadNotFound", err)
+	}
+	if err := u.Delete("missing"); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("got %v, want ErrUploadNotFound", err)
+	}
+
+	// ملف القفل لا يُنشئ مجلداً لرفع غير موجود
+	if _, err := os.Stat(u.uploadDir("missing")); !os.IsNotExist(err) {
+		t.Fatalf("lock created a directory: %v", err)
+	}
+}
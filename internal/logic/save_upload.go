@@ -0,0 +1,66 @@
+package logic
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SaveUpload يكتب الملف مباشرة من الطلب إلى القرص
+//
+// size هو الحجم المتوقع (Content-Length) أو 0 إذا كان غير معروف،
+// الحصة تُحجز قبل الكتابة (size ثم على دفعات للطلبات بدون طول)
+// وتُضبط على الحجم الفعلي بعد الكتابة
+func SaveUpload(file io.Reader, filename string, baseDir string, uploader string, size int64) (string, error) {
+
+	// تأكد من وجود المجلد
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return "", err
+	}
+
+	// حماية من path traversal
+	safeName := filepath.Base(filename)
+	dstPath := filepath.Join(baseDir, safeName)
+
+	quota, err := UploadsQuota()
+	if err != nil {
+		return "", err
+	}
+
+	// الكتابة إلى ملف مؤقت حتى لا يبقى ملف ناقص عند الفشل
+	partPath := dstPath + ".part"
+	dst, err := os.Create(partPath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(partPath)
+	defer dst.Close()
+
+	// رفض الكتابة إذا كانت ستتجاوز حصة القرص
+	reserved, err := quota.NewQuotaWriter(uploader, dstPath, size, dst)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(reserved, file); err != nil {
+		quota.Release(dstPath)
+		return "", err
+	}
+
+	if err := dst.Close(); err != nil {
+		quota.Release(dstPath)
+		return "", err
+	}
+
+	if err := reserved.Commit(); err != nil {
+		quota.Release(dstPath)
+		return "", err
+	}
+
+	if err := os.Rename(partPath, dstPath); err != nil {
+		quota.Release(dstPath)
+		return "", err
+	}
+
+	return dstPath, nil
+}
@@ -0,0 +1,545 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// استراتيجيات تقسيم ملف PCAP
+//
+//	count:N          كل N حزمة (الافتراضي count:1000)
+//	bytes:SIZE       كل SIZE بايت تقريباً (مثل 50MB)
+//	time:DURATION    نافذة زمنية حسب وقت الحزم (مثل 60s)
+//	flow:N           hash الـ 5-tuple في N ملف (الاتجاهان في نفس الملف)
+//	session:N        حوالي N حزمة لكل ملف بدون قطع أي اتصال بين ملفين
+//	host             ملف لكل زوج IP (الاتجاهان في نفس الملف)
+//	host:IP,IP       ملف لكل IP من القائمة (مرسِل أو مستقبِل) والباقي في other
+//
+// تُمرر كنص في ?split= أو في Upload-Metadata أو في PcapUploadedEvent.Split
+
+const (
+	SplitCount = "count"
+	SplitBytes = "bytes"
+	SplitTime  = "time"
+	SplitFlow  = "flow"
+	SplitHost  = "host"
+
+	SplitSession = "session"
+
+	// MaxSplitBuckets أقصى عدد ملفات مفتوحة في نفس الوقت (flow/host)
+	MaxSplitBuckets = 256
+
+	// sessionIdleTimeout اتصال بدون حزم لهذه المدة (حسب توقيت الحزم) يُعتبر منتهياً
+	sessionIdleTimeout = 2 * time.Minute
+
+	// otherChunk الحزم التي لا تخص أي host محدد (أو بعد MaxSplitBuckets)
+	otherChunk = "other"
+)
+
+// ErrInvalidSplit يُرجع عند نص تقسيم غير صالح
+var ErrInvalidSplit = errors.New("invalid split")
+
+// CodeInvalidSplit رمز الخطأ في رد الـ API
+const CodeInvalidSplit = "invalid_split"
+
+// SplitSpec إعدادات التقسيم المطلوبة
+type SplitSpec struct {
+	Strategy string
+	Packets  int
+	Bytes    int64
+	Window   time.Duration
+	Buckets  int
+	Hosts    []netip.Addr
+}
+
+// DefaultSplitSpec التقسيم القديم: كل MaxPacketsPerChunk حزمة
+func DefaultSplitSpec() SplitSpec {
+	return SplitSpec{Strategy: SplitCount, Packets: MaxPacketsPerChunk}
+}
+
+// ParseSplitSpec يقرأ نص التقسيم، النص الفارغ يعني DefaultSplitSpec
+func ParseSplitSpec(s string) (SplitSpec, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultSplitSpec(), nil
+	}
+
+	strategy, arg, hasArg := strings.Cut(s, ":")
+	invalid := func(reason string) (SplitSpec, error) {
+		return SplitSpec{}, fmt.Errorf("%w %q: %s", ErrInvalidSplit, s, reason)
+	}
+
+	spec := SplitSpec{Strategy: strategy}
+	switch strategy {
+	case SplitCount:
+		spec.Packets = MaxPacketsPerChunk
+		if hasArg {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return invalid("packet count must be a positive number")
+			}
+			spec.Packets = n
+		}
+
+	case SplitSession:
+		spec.Packets = MaxPacketsPerChunk
+		if hasArg {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return invalid("target packet count must be a positive number")
+			}
+			spec.Packets = n
+		}
+
+	case SplitBytes:
+		n, err := parseByteSize(arg)
+		if err != nil || n <= 0 {
+			return invalid("size must be positive, e.g. 50MB")
+		}
+		spec.Bytes = n
+
+	case SplitTime:
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return invalid("window must be a positive duration, e.g. 60s")
+		}
+		spec.Window = d
+
+	case SplitFlow:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 || n > MaxSplitBuckets {
+			return invalid(fmt.Sprintf("bucket count must be 1-%d", MaxSplitBuckets))
+		}
+		spec.Buckets = n
+
+	case SplitHost:
+		if hasArg {
+			for _, h := range strings.Split(arg, ",") {
+				addr, err := netip.ParseAddr(strings.TrimSpace(h))
+				if err != nil {
+					return invalid("hosts must be IP addresses")
+				}
+				spec.Hosts = append(spec.Hosts, addr)
+			}
+			if len(spec.Hosts) >= MaxSplitBuckets {
+				return invalid(fmt.Sprintf("at most %d hosts", MaxSplitBuckets-1))
+			}
+		}
+
+	default:
+		return invalid("strategy must be count, bytes, time, flow, host or session")
+	}
+
+	return spec, nil
+}
+
+// String يرجع النص الذي يعيد ParseSplitSpec نفس الإعدادات
+func (s SplitSpec) String() string {
+	switch s.Strategy {
+	case SplitCount:
+		return fmt.Sprintf("count:%d", s.Packets)
+	case SplitBytes:
+		return fmt.Sprintf("bytes:%d", s.Bytes)
+	case SplitTime:
+		return "time:" + s.Window.String()
+	case SplitFlow:
+		return fmt.Sprintf("flow:%d", s.Buckets)
+	case SplitSession:
+		return fmt.Sprintf("session:%d", s.Packets)
+	case SplitHost:
+		if len(s.Hosts) == 0 {
+			return "host"
+		}
+		hosts := make([]string, len(s.Hosts))
+		for i, h := range s.Hosts {
+			hosts[i] = h.String()
+		}
+		return "host:" + strings.Join(hosts, ",")
+	}
+	return ""
+}
+
+// Splitter يحدد الجزء الذي تُكتب فيه كل حزمة
+type Splitter interface {
+	// Assign يرجع اسم الجزء (يدخل في اسم الملف)
+	Assign(ci gopacket.CaptureInfo, data []byte) string
+
+	// Sequential: الأجزاء متتالية، أي أن الجزء السابق لن يتكرر
+	// ويمكن إغلاقه عند ظهور جزء جديد
+	Sequential() bool
+}
+
+// ChunkFinisher يُنفذه Splitter غير متتالٍ يعرف متى ينتهي جزء،
+// فيُغلق ملفه قبل نهاية الملف الأصلي
+type ChunkFinisher interface {
+	// Finished الأجزاء التي لن تُستخدم بعد آخر Assign
+	Finished() []string
+}
+
+// NewSplitter ينشئ الـ Splitter، linkType نوع ربط الواجهة الأولى
+// (حزم pcapng من واجهات أخرى تحمل نوعها في ci.AncillaryData)
+// و recordSize حجم الحزمة في ملف الجزء (انظر chunkRecordSize)
+func (s SplitSpec) NewSplitter(linkType layers.LinkType, recordSize func(gopacket.CaptureInfo) int64) Splitter {
+	switch s.Strategy {
+	case SplitBytes:
+		return &byteSplitter{limit: s.Bytes, recordSize: recordSize}
+	case SplitTime:
+		return &timeSplitter{window: s.Window}
+	case SplitFlow:
+		return &flowSplitter{buckets: uint64(s.Buckets), linkType: linkType}
+	case SplitHost:
+		return &hostSplitter{hosts: s.Hosts, linkType: linkType, seen: make(map[string]bool)}
+	case SplitSession:
+		return newSessionSplitter(max(s.Packets, 1), linkType)
+	}
+	return &countSplitter{limit: max(s.Packets, 1)}
+}
+
+/*
+========================
+SEQUENTIAL SPLITTERS
+========================
+*/
+
+// countSplitter جزء جديد كل limit حزمة
+type countSplitter struct {
+	limit int
+	count int
+}
+
+func (s *countSplitter) Assign(gopacket.CaptureInfo, []byte) string {
+	chunk := s.count / s.limit
+	s.count++
+	return strconv.Itoa(chunk)
+}
+
+func (s *countSplitter) Sequential() bool { return true }
+
+// byteSplitter جزء جديد عندما يتجاوز الحجم limit
+// NOTE: الحجم كما يكتبه chunkWriter (رأس السجل يختلف بين pcap و pcapng)
+// بدون رأس الملف، والحزمة الواحدة الأكبر من limit تكون جزءاً وحدها
+type byteSplitter struct {
+	limit      int64
+	recordSize func(gopacket.CaptureInfo) int64
+	size       int64
+	chunk      int
+}
+
+func (s *byteSplitter) Assign(ci gopacket.CaptureInfo, _ []byte) string {
+	record := s.recordSize(ci)
+	if s.size > 0 && s.size+record > s.limit {
+		s.chunk++
+		s.size = 0
+	}
+	s.size += record
+	return strconv.Itoa(s.chunk)
+}
+
+func (s *byteSplitter) Sequential() bool { return true }
+
+// timeSplitter جزء لكل نافذة زمنية (حسب توقيت الحزم وليس وقت المعالجة)
+// NOTE: النوافذ محاذاة للساعة (60s تبدأ عند الثانية 0)،
+// والنوافذ الفارغة لا تنتج ملفات
+type timeSplitter struct {
+	window  time.Duration
+	current time.Time
+	chunk   int
+	started bool
+}
+
+func (s *timeSplitter) Assign(ci gopacket.CaptureInfo, _ []byte) string {
+	start := ci.Timestamp.Truncate(s.window)
+	switch {
+	case !s.started:
+		s.started = true
+		s.current = start
+	case !start.Equal(s.current):
+		// حزمة متأخرة قليلاً تبقى في الجزء الحالي بدل فتح جزء قديم
+		if start.After(s.current) {
+			s.current = start
+			s.chunk++
+		}
+	}
+	return strconv.Itoa(s.chunk)
+}
+
+func (s *timeSplitter) Sequential() bool { return true }
+
+/*
+========================
+BUCKET SPLITTERS
+========================
+*/
+
+// flowSplitter يوزع الاتصالات على buckets حسب hash الـ 5-tuple
+// NOTE: FastHash متماثل (A→B = B→A) فيبقى الاتصال كاملاً في نفس الملف.
+// الحزم غير IP تذهب إلى flow0
+type flowSplitter struct {
+	buckets  uint64
+	linkType layers.LinkType
+}
+
+func (s *flowSplitter) Assign(ci gopacket.CaptureInfo, data []byte) string {
+	packet := gopacket.NewPacket(data, packetLinkType(ci, s.linkType), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	var hash uint64
+	if network := packet.NetworkLayer(); network != nil {
+		hash = network.NetworkFlow().FastHash()
+		if transport := packet.TransportLayer(); transport != nil {
+			// الجمع يحافظ على التماثل
+			hash += transport.TransportFlow().FastHash()
+		}
+	}
+
+	return fmt.Sprintf("flow%d", hash%s.buckets)
+}
+
+func (s *flowSplitter) Sequential() bool { return false }
+
+// hostSplitter ملف لكل host من القائمة، أو لكل زوج hosts بدون قائمة
+// NOTE: الزوج بترتيب ثابت (A↔B) فيبقى الاتجاهان في نفس الملف
+type hostSplitter struct {
+	hosts    []netip.Addr
+	linkType layers.LinkType
+	seen     map[string]bool
+}
+
+func (s *hostSplitter) Assign(ci gopacket.CaptureInfo, data []byte) string {
+	packet := gopacket.NewPacket(data, packetLinkType(ci, s.linkType), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	network := packet.NetworkLayer()
+	if network == nil {
+		return otherChunk
+	}
+
+	src, srcOK := netip.AddrFromSlice(network.NetworkFlow().Src().Raw())
+	dst, dstOK := netip.AddrFromSlice(network.NetworkFlow().Dst().Raw())
+
+	// قائمة محددة: الحزمة تذهب لأول host مذكور (مرسِل أو مستقبِل)
+	if len(s.hosts) > 0 {
+		for _, h := range s.hosts {
+			if (srcOK && src.Unmap() == h.Unmap()) || (dstOK && dst.Unmap() == h.Unmap()) {
+				return hostChunk(h)
+			}
+		}
+		return otherChunk
+	}
+
+	// بدون قائمة: ملف لكل زوج حتى MaxSplitBuckets
+	if !srcOK || !dstOK {
+		return otherChunk
+	}
+	key := hostPairChunk(src, dst)
+	if !s.seen[key] {
+		if len(s.seen) >= MaxSplitBuckets-1 {
+			return otherChunk
+		}
+		s.seen[key] = true
+	}
+	return key
+}
+
+func (s *hostSplitter) Sequential() bool { return false }
+
+// hostChunk اسم آمن لملف (":" في IPv6 تصبح "-")
+func hostChunk(addr netip.Addr) string {
+	return "host-" + hostName(addr)
+}
+
+// hostPairChunk اسم ملف الزوج: الأصغر أولاً (host-10.0.0.1_10.0.0.2)
+func hostPairChunk(a netip.Addr, b netip.Addr) string {
+	a, b = a.Unmap(), b.Unmap()
+	if b.Less(a) {
+		a, b = b, a
+	}
+	return "host-" + hostName(a) + "_" + hostName(b)
+}
+
+func hostName(addr netip.Addr) string {
+	return strings.ReplaceAll(addr.Unmap().String(), ":", "-")
+}
+
+/*
+========================
+SESSION SPLITTER
+========================
+*/
+
+// sessionKey الاتصال بترتيب ثابت (A→B و B→A نفس المفتاح)
+type sessionKey struct {
+	network   gopacket.Flow
+	transport gopacket.Flow
+}
+
+// sessionOf يرجع مفتاح الاتصال، false للحزم غير IP
+func sessionOf(packet gopacket.Packet) (sessionKey, bool) {
+	network := packet.NetworkLayer()
+	if network == nil {
+		return sessionKey{}, false
+	}
+
+	key := sessionKey{network: network.NetworkFlow()}
+	if transport := packet.TransportLayer(); transport != nil {
+		key.transport = transport.TransportFlow()
+	}
+
+	src, dst := key.network.Endpoints()
+	if dst.LessThan(src) || (src == dst && key.transport.Dst().LessThan(key.transport.Src())) {
+		key.network = key.network.Reverse()
+		key.transport = key.transport.Reverse()
+	}
+	return key, true
+}
+
+type sessionState struct {
+	chunk  int
+	last   time.Time
+	fins   int
+	closed bool
+}
+
+// sessionSplitter يملأ الجزء الحالي حتى target حزمة تقريباً،
+// ثم تبدأ الاتصالات الجديدة جزءاً جديداً بينما تكمل الاتصالات
+// المفتوحة في أجزائها. الجزء يُغلق عندما تنتهي كل اتصالاته
+// (FIN من الطرفين أو RST أو sessionIdleTimeout).
+// NOTE: target حد تقريبي، الاتصال الطويل يكبر جزءه بعده.
+// إذا تجاوزت الأجزاء المفتوحة MaxSplitBuckets يُغلق أقدمها،
+// وتنتقل بقية حزم اتصالاته إلى الجزء الحالي (الحالة الوحيدة التي يُقطع فيها اتصال)
+type sessionSplitter struct {
+	target   int
+	linkType layers.LinkType
+
+	current  int
+	count    int
+	sessions map[sessionKey]*sessionState
+	active   map[int]int  // عدد الاتصالات في كل جزء
+	open     map[int]bool // الأجزاء التي لم تنته
+	finished []string
+}
+
+func newSessionSplitter(target int, linkType layers.LinkType) *sessionSplitter {
+	return &sessionSplitter{
+		target:   target,
+		linkType: linkType,
+		sessions: make(map[sessionKey]*sessionState),
+		active:   make(map[int]int),
+		open:     map[int]bool{0: true},
+	}
+}
+
+func (s *sessionSplitter) Assign(ci gopacket.CaptureInfo, data []byte) string {
+	s.finished = s.finished[:0]
+
+	packet := gopacket.NewPacket(data, packetLinkType(ci, s.linkType), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	key, ok := sessionOf(packet)
+	if !ok {
+		// الحزم غير IP تبقى في الجزء الحالي
+		s.count++
+		return strconv.Itoa(s.current)
+	}
+
+	session := s.sessions[key]
+	if session == nil {
+		// الاتصالات الجديدة فقط تنتقل إلى جزء جديد
+		if s.count >= s.target {
+			s.roll(ci.Timestamp)
+		}
+		session = &sessionState{chunk: s.current}
+		s.sessions[key] = session
+		s.active[s.current]++
+	}
+	if session.chunk == s.current {
+		s.count++
+	}
+	session.last = ci.Timestamp
+
+	// نهاية TCP: يُحذف الاتصال عند الجزء التالي وليس فوراً
+	// حتى يبقى ACK الأخير مع بقية الاتصال
+	if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		if tcp.FIN {
+			session.fins++
+		}
+		if tcp.RST || session.fins >= 2 {
+			session.closed = true
+		}
+	}
+
+	return strconv.Itoa(session.chunk)
+}
+
+func (s *sessionSplitter) Sequential() bool { return false }
+
+func (s *sessionSplitter) Finished() []string { return s.finished }
+
+// roll يبدأ جزءاً جديداً ويُنهي الأجزاء التي لم يبق فيها اتصال مفتوح
+func (s *sessionSplitter) roll(now time.Time) {
+	for key, session := range s.sessions {
+		if session.closed || now.Sub(session.last) > sessionIdleTimeout {
+			s.drop(key, session)
+		}
+	}
+
+	s.current++
+	s.count = 0
+	s.open[s.current] = true
+
+	for chunk := range s.open {
+		if chunk != s.current && s.active[chunk] == 0 {
+			s.finish(chunk)
+		}
+	}
+
+	// حد الملفات المفتوحة: إغلاق الأقدم
+	for len(s.open) > MaxSplitBuckets {
+		oldest := s.current
+		for chunk := range s.open {
+			oldest = min(oldest, chunk)
+		}
+		for key, session := range s.sessions {
+			if session.chunk == oldest {
+				s.drop(key, session)
+			}
+		}
+		s.finish(oldest)
+	}
+}
+
+func (s *sessionSplitter) drop(key sessionKey, session *sessionState) {
+	delete(s.sessions, key)
+	if s.active[session.chunk]--; s.active[session.chunk] <= 0 {
+		delete(s.active, session.chunk)
+	}
+}
+
+func (s *sessionSplitter) finish(chunk int) {
+	delete(s.open, chunk)
+	s.finished = append(s.finished, strconv.Itoa(chunk))
+}
+
+// parseByteSize يقرأ أحجاماً مثل 1048576 أو 512KB أو 50MB أو 1GB
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
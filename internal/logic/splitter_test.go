@@ -0,0 +1,180 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestChunkRecordSizeMatchesWriter(t *testing.T) {
+	// 61 بايت: البيانات في pcapng تحتاج padding
+	for _, output := range []string{OutputPcap, OutputPcapNG} {
+		t.Run(output, func(t *testing.T) {
+			one := chunkSize(t, testCapture(t, 1, 61), output)
+			three := chunkSize(t, testCapture(t, 3, 61), output)
+
+			ci := gopacket.CaptureInfo{CaptureLength: 61, Length: 61}
+			if got, want := chunkRecordSize(output)(ci), (three-one)/2; got != want {
+				t.Fatalf("record size %d, writer wrote %d per packet", got, want)
+			}
+		})
+	}
+}
+
+// chunkSize حجم الجزء الوحيد الناتج عن capture
+func chunkSize(t *testing.T, capture []byte, output string) int64 {
+	t.Helper()
+
+	fs := infra.NewMemFileSystem()
+	names, err := ProcessPcapWith(fs, bytes.NewReader(capture), "cap.pcap", ProcessOptions{Split: DefaultSplitSpec(), Output: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("got %d chunks, want 1", len(names))
+	}
+
+	data, err := fs.ReadFile(filepath.Join(OutputDir, names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(data))
+}
+
+func TestHostSplitterKeysOnPair(t *testing.T) {
+	spec, err := ParseSplitSpec("host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitter := spec.NewSplitter(layers.LinkTypeEthernet, chunkRecordSize(OutputPcap))
+
+	assign := func(src string, dst string) string {
+		data := ipPacket(t, src, dst)
+		return splitter.Assign(gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, data)
+	}
+
+	request := assign("10.0.0.2", "10.0.0.1")
+	reply := assign("10.0.0.1", "10.0.0.2")
+	other := assign("10.0.0.1", "10.0.0.3")
+
+	if request != "host-10.0.0.1_10.0.0.2" {
+		t.Fatalf("got %q, want host-10.0.0.1_10.0.0.2", request)
+	}
+	if reply != request {
+		t.Fatalf("reply went to %q, request to %q", reply, request)
+	}
+	if other == request {
+		t.Fatalf("another pair shares %q", other)
+	}
+}
+
+// ipPacket حزمة Ethernet/IPv4/UDP بين src و dst
+func ipPacket(t *testing.T, src string, dst string) []byte {
+	t.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP(src).To4(),
+		DstIP:    net.ParseIP(dst).To4(),
+	}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload("query")); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSessionSplitterKeepsConnections(t *testing.T) {
+	spec, err := ParseSplitSpec("session:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitter := spec.NewSplitter(layers.LinkTypeEthernet, chunkRecordSize(OutputPcap))
+	finisher := splitter.(ChunkFinisher)
+
+	start := time.Unix(1000, 0)
+	var finished []string
+	assign := func(src string, srcPort int, dst string, dstPort int, fin bool) string {
+		data := tcpPacket(t, src, srcPort, dst, dstPort, fin)
+		chunk := splitter.Assign(gopacket.CaptureInfo{Timestamp: start, CaptureLength: len(data), Length: len(data)}, data)
+		finished = append(finished, finisher.Finished()...)
+		return chunk
+	}
+
+	// الاتصال A يملأ الجزء 0
+	a := assign("10.0.0.1", 40000, "10.0.0.9", 80, false)
+	assign("10.0.0.9", 80, "10.0.0.1", 40000, false)
+
+	// اتصال جديد بعد الهدف يبدأ الجزء 1، و A يكمل في جزئه بالاتجاهين
+	b := assign("10.0.0.2", 40001, "10.0.0.9", 80, false)
+	if b == a {
+		t.Fatalf("new connection stayed in chunk %s past the target", a)
+	}
+	for _, fin := range []bool{false, true} {
+		if got := assign("10.0.0.1", 40000, "10.0.0.9", 80, fin); got != a {
+			t.Fatalf("request went to %s, connection is in %s", got, a)
+		}
+		if got := assign("10.0.0.9", 80, "10.0.0.1", 40000, fin); got != a {
+			t.Fatalf("reply went to %s, connection is in %s", got, a)
+		}
+	}
+	if got := assign("10.0.0.9", 80, "10.0.0.2", 40001, false); got != b {
+		t.Fatalf("reply of B went to %s, want %s", got, b)
+	}
+	if len(finished) != 0 {
+		t.Fatalf("finished %v while connections are open", finished)
+	}
+
+	// A انتهى (FIN من الطرفين): الجزء 0 يُغلق عند الجزء التالي
+	c := assign("10.0.0.3", 40002, "10.0.0.9", 80, false)
+	if c == a || c == b {
+		t.Fatalf("third connection went to %s", c)
+	}
+	if len(finished) != 1 || finished[0] != a {
+		t.Fatalf("finished %v, want [%s]", finished, a)
+	}
+}
+
+// tcpPacket حزمة Ethernet/IPv4/TCP من src:srcPort إلى dst:dstPort
+func tcpPacket(t *testing.T, src string, srcPort int, dst string, dstPort int, fin bool) []byte {
+	t.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(src).To4(),
+		DstIP:    net.ParseIP(dst).To4(),
+	}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), ACK: true, FIN: fin, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// التحقق من الأجزاء بعد التقسيم
+//
+// كل حزمة تُختصر إلى سجل: التوقيت (ns) + caplen + length + نوع الربط + البيانات.
+// أثناء القراءة من الأصل يُحسب digest، ثم تُقرأ الأجزاء من القرص بعد إغلاقها
+// ويجب أن تعطي نفس السجلات:
+//   - التقسيم المتتالي (count/bytes/time): الأجزاء بالترتيب = الأصل بنفس الترتيب
+//   - بقية الاستراتيجيات: نفس السجلات بغض النظر عن الترتيب
+
+// ErrChunkVerification يُرجع عندما لا تطابق الأجزاء الملف الأصلي
+var ErrChunkVerification = errors.New("chunk verification failed")
+
+// recordDigest ملخص سجلات الحزم
+type recordDigest struct {
+	count   int
+	ordered hash.Hash // sha256 لكل السجلات بالترتيب
+	sum     [2]uint64 // مجموع sha256 لكل سجل (لا يتأثر بالترتيب)
+	header  [18]byte
+}
+
+func newRecordDigest() *recordDigest {
+	return &recordDigest{ordered: sha256.New()}
+}
+
+// Add يضيف سجل حزمة
+func (d *recordDigest) Add(ci gopacket.CaptureInfo, linkType layers.LinkType, data []byte) {
+	binary.BigEndian.PutUint64(d.header[0:8], uint64(ci.Timestamp.UnixNano()))
+	binary.BigEndian.PutUint32(d.header[8:12], uint32(ci.CaptureLength))
+	binary.BigEndian.PutUint32(d.header[12:16], uint32(ci.Length))
+	binary.BigEndian.PutUint16(d.header[16:18], uint16(linkType))
+
+	d.ordered.Write(d.header[:])
+	d.ordered.Write(data)
+
+	record := sha256.New()
+	record.Write(d.header[:])
+	record.Write(data)
+	sum := record.Sum(nil)
+	d.sum[0] += binary.BigEndian.Uint64(sum[0:8])
+	d.sum[1] += binary.BigEndian.Uint64(sum[8:16])
+
+	d.count++
+}
+
+// Match يقارن ملخصين، ordered: الترتيب يجب أن يكون نفسه
+func (d *recordDigest) Match(other *recordDigest, ordered bool) bool {
+	if d.count != other.count || d.sum != other.sum {
+		return false
+	}
+	if !ordered {
+		return true
+	}
+	return string(d.ordered.Sum(nil)) == string(other.ordered.Sum(nil))
+}
+
+// verifyChunks يقرأ الأجزاء بالترتيب ويقارنها مع ملخص الأصل
+func verifyChunks(fs infra.FileSystem, chunkNames []string, want *recordDigest, ordered bool) error {
+	got := newRecordDigest()
+
+	for _, name := range chunkNames {
+		if err := digestChunk(fs, name, got); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrChunkVerification, name, err)
+		}
+	}
+
+	if got.count != want.count {
+		return fmt.Errorf("%w: capture has %d packets, chunks have %d", ErrChunkVerification, want.count, got.count)
+	}
+	if !got.Match(want, ordered) {
+		return fmt.Errorf("%w: packet records differ from the capture", ErrChunkVerification)
+	}
+	return nil
+}
+
+// digestChunk يضيف سجلات ملف جزء واحد إلى d
+func digestChunk(fs infra.FileSystem, name string, d *recordDigest) error {
+	file, err := fs.Open(filepath.Join(OutputDir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// الأجزاء يكتبها ProcessPcapWith بدون ضغط
+	format, capture, err := infra.OpenCapture(file, 0)
+	if err != nil {
+		return err
+	}
+
+	source, err := openCaptureSource(format, capture)
+	if err != nil {
+		return err
+	}
+
+	for {
+		data, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.Add(ci, source.PacketLinkType(ci), data)
+	}
+}
@@ -0,0 +1,147 @@
+package logic
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestVerifyChunksReadsChunksBack(t *testing.T) {
+	capture := testCapture(t, 4, 10)
+	for i := range 4 {
+		// بيانات مختلفة لكل حزمة حتى يظهر تبديل الترتيب
+		capture[len(capture)-(4-i)*26] = byte(i + 1)
+	}
+
+	fs := infra.NewMemFileSystem()
+	names, err := ProcessPcapWith(fs, bytes.NewReader(capture), "cap.pcap", ProcessOptions{Split: SplitSpec{Strategy: SplitCount, Packets: 2}, Output: OutputPcap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(names))
+	}
+
+	want := newRecordDigest()
+	source, err := openCaptureSource(infra.CaptureFormat{Container: infra.FormatPcap}, bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		data, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Add(ci, source.PacketLinkType(ci), data)
+	}
+
+	if err := verifyChunks(fs, names, want, true); err != nil {
+		t.Fatalf("untouched chunks: %v", err)
+	}
+
+	reversed := []string{names[1], names[0]}
+	if err := verifyChunks(fs, reversed, want, true); !errors.Is(err, ErrChunkVerification) {
+		t.Fatalf("chunks out of order: got %v, want ErrChunkVerification", err)
+	}
+	if err := verifyChunks(fs, reversed, want, false); err != nil {
+		t.Fatalf("chunks out of order, unordered check: %v", err)
+	}
+	if err := verifyChunks(fs, names[:1], want, true); !errors.Is(err, ErrChunkVerification) {
+		t.Fatalf("missing chunk: got %v, want ErrChunkVerification", err)
+	}
+
+	// بايت واحد تغيّر على القرص بعد الكتابة
+	path := filepath.Join(OutputDir, names[1])
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xFF
+	if err := fs.WriteFile(path, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyChunks(fs, names, want, true); !errors.Is(err, ErrChunkVerification) {
+		t.Fatalf("changed chunk: got %v, want ErrChunkVerification", err)
+	}
+}
+
+func TestPcapChunksKeyedByResolution(t *testing.T) {
+	// واجهتان Ethernet: الأولى بالميكروثانية (الافتراضي) والثانية بالنانوثانية،
+	// فالجزء يبدأ micro وحزم الواجهة الثانية لا تدخله
+	le := binary.LittleEndian
+	var capture []byte
+	capture = le.AppendUint32(capture, ngBlockSectionHeader)
+	capture = le.AppendUint32(capture, 28)
+	capture = le.AppendUint32(capture, ngByteOrderMagic)
+	capture = le.AppendUint16(capture, 1)
+	capture = le.AppendUint16(capture, 0)
+	capture = le.AppendUint64(capture, ^uint64(0))
+	capture = le.AppendUint32(capture, 28)
+	for _, options := range [][]byte{nil, {9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0}} {
+		length := uint32(20 + len(options))
+		capture = le.AppendUint32(capture, 1)
+		capture = le.AppendUint32(capture, length)
+		capture = le.AppendUint16(capture, uint16(layers.LinkTypeEthernet))
+		capture = le.AppendUint16(capture, 0)
+		capture = le.AppendUint32(capture, 65535)
+		capture = append(capture, options...)
+		capture = le.AppendUint32(capture, length)
+	}
+
+	// التوقيت بوحدة واجهة الحزمة
+	start := time.Unix(1700000000, 123456789)
+	for i := range 4 {
+		intf := i % 2
+		ts := uint64(start.Add(time.Duration(i) * time.Second).UnixNano())
+		if intf == 0 {
+			ts /= 1000
+		}
+		data := bytes.Repeat([]byte{byte(i)}, 60)
+		capture = le.AppendUint32(capture, ngBlockEnhancedPacket)
+		capture = le.AppendUint32(capture, 32+60)
+		capture = le.AppendUint32(capture, uint32(intf))
+		capture = le.AppendUint32(capture, uint32(ts>>32))
+		capture = le.AppendUint32(capture, uint32(ts))
+		capture = le.AppendUint32(capture, 60)
+		capture = le.AppendUint32(capture, 60)
+		capture = append(capture, data...)
+		capture = le.AppendUint32(capture, 32+60)
+	}
+
+	fs := infra.NewMemFileSystem()
+	names, err := ProcessPcapWith(fs, bytes.NewReader(capture), "cap.pcapng", ProcessOptions{Split: DefaultSplitSpec(), Output: OutputPcap})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]uint32{
+		"chunk_0_cap.pcap":     0xa1b2c3d4, // micro
+		"chunk_0_if1_cap.pcap": 0xa1b23c4d, // nano
+	}
+	if len(names) != len(want) {
+		t.Fatalf("got chunks %v, want %d", names, len(want))
+	}
+	for _, name := range names {
+		magic, ok := want[name]
+		if !ok {
+			t.Fatalf("unexpected chunk %s", name)
+		}
+		data, err := fs.ReadFile(filepath.Join(OutputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := le.Uint32(data); got != magic {
+			t.Fatalf("%s: magic %#x, want %#x", name, got, magic)
+		}
+	}
+}
@@ -5,49 +5,181 @@ import (
 	"LM-Gate/internal/infra"
 	"LM-Gate/internal/logic"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"path/filepath"
+	"time"
 )
 
+// jobProgressInterval أقل مدة بين تحديثين لتقدم المهمة
+const jobProgressInterval = time.Second
+
 // RegisterPcapUploaded
 // هذه الدالة تربط الحدث مع RabbitMQ
 // work هو المسؤول عن "التشغيل"
-func RegisterPcapUploaded(rabbit *infra.RabbitClient) {
-	rabbit.ConsumeMessages("pcap_processing_queue", func(body []byte) {
+//
+// NOTE: المهام العالقة في running (worker توقف أثناء المعالجة) تُعاد أولاً
+// إلى الطابور عبر publisher. الرسالة تُؤكد فقط بعد وصول المهمة إلى
+// succeeded أو failed، وإلا تعود إلى الطابور (ConsumeWithAck).
+func RegisterPcapUploaded(rabbit *infra.RabbitClient, jobs *logic.JobStore, publisher *logic.EventPublisher) error {
+	requeued, err := jobs.RequeueRunning(publisher)
+	if err != nil {
+		return err
+	}
+	if len(requeued) > 0 {
+		log.Printf("🔁 requeued %d interrupted jobs: %v", len(requeued), requeued)
+	}
+
+	return rabbit.ConsumeWithAck(logic.QueueName, func(body []byte) error {
 
 		var event events.PcapUploadedEvent
 		if err := json.Unmarshal(body, &event); err != nil {
 			log.Printf("❌ invalid pcap_uploaded event: %v", err)
-			return
+			return infra.Permanent(err)
 		}
 
-		OnPcapUploaded(event)
+		return OnPcapUploaded(jobs, event)
 	})
 }
 
 // OnPcapUploaded
-func OnPcapUploaded(event events.PcapUploadedEvent) {
+// تنفيذ المعالجة مع تحديث حالة المهمة: running → succeeded/failed
+//
+// NOTE: يرجع nil فقط عندما تصل المهمة إلى حالة نهائية (أو كانت منتهية
+// من رسالة سابقة)، والخطأ يعني أن الرسالة يجب أن تُعاد.
+func OnPcapUploaded(jobs *logic.JobStore, event events.PcapUploadedEvent) error {
 	log.Printf("📥 PCAP file received: %s", event.FileName)
 
-	// 1️⃣ إنشاء FileSystem
+	// 1️⃣ تحديد المهمة (الأحداث بدون JobID تحصل على مهمة جديدة)
+	jobID, err := startJob(jobs, event)
+	if errors.Is(err, logic.ErrJobFinished) {
+		log.Printf("⏭️ job %s already finished, skipping duplicate event", event.JobID)
+		return nil
+	}
+	if err != nil {
+		log.Printf("❌ failed to start job for %s: %v", event.FileName, err)
+		return err
+	}
+
+	// استراتيجية التقسيم وصيغة الأجزاء (الفارغ = كل MaxPacketsPerChunk حزمة في pcap)
+	opts, err := logic.ParseProcessOptions(event.Split, event.Output)
+	if err != nil {
+		log.Printf("❌ invalid options for %s: %v", event.FileName, err)
+		return failJob(jobs, jobID, err)
+	}
+
+	// 2️⃣ إنشاء FileSystem
 	fs := infra.NewLocalFileSystem()
 
-	// 2️⃣ فتح ملف PCAP عبر FileSystem
+	// 3️⃣ فتح ملف PCAP عبر FileSystem
 	file, err := fs.Open(event.Path)
 	if err != nil {
 		log.Printf("❌ failed to open pcap file: %v", err)
-		return
+		return failJob(jobs, jobID, err)
 	}
 	defer file.Close()
 
-	// 3️⃣ تنفيذ المعالجة الفعلية
-	if _, err := logic.ProcessPcap(
+	size := event.Size
+	if info, err := fs.Stat(event.Path); err == nil {
+		size = info.Size()
+	}
+
+	// 4️⃣ تنفيذ المعالجة الفعلية مع تسجيل التقدم
+	progress := &jobProgressReader{r: file, total: size, jobs: jobs, id: jobID}
+	chunks, err := logic.ProcessPcapWith(
 		fs,
-		file,
+		progress,
 		event.FileName,
-	); err != nil {
+		opts,
+	)
+	if err != nil {
 		log.Printf("❌ PCAP processing failed: %v", err)
-		return
+		return failJob(jobs, jobID, err)
+	}
+
+	artifacts := make([]string, len(chunks))
+	for i, name := range chunks {
+		artifacts[i] = filepath.Join(logic.OutputDir, name)
+	}
+
+	if _, err := jobs.Succeed(jobID, artifacts); err != nil {
+		log.Printf("⚠️ failed to update job %s: %v", jobID, err)
+		return err
+	}
+
+	log.Printf("✅ PCAP processed successfully: %s (job %s)", event.FileName, jobID)
+	return nil
+}
+
+// startJob ينقل المهمة إلى running، أو ينشئها إذا لم تكن موجودة
+func startJob(jobs *logic.JobStore, event events.PcapUploadedEvent) (string, error) {
+	jobID := event.JobID
+	if jobID != "" {
+		_, err := jobs.Start(jobID)
+		if !errors.Is(err, logic.ErrJobNotFound) {
+			return jobID, err
+		}
+	} else {
+		id, err := logic.NewJobID()
+		if err != nil {
+			return "", err
+		}
+		jobID = id
+	}
+
+	job := &logic.Job{
+		ID:       jobID,
+		FileName: event.FileName,
+		Path:     event.Path,
+		Size:     event.Size,
+		Split:    event.Split,
+		Output:   event.Output,
+	}
+	if _, err := jobs.Create(job); err != nil {
+		return "", err
+	}
+	if _, err := jobs.Start(jobID); err != nil {
+		return "", err
+	}
+
+	log.Printf("🆔 job %s created for %s", jobID, event.FileName)
+	return jobID, nil
+}
+
+// failJob ينهي المهمة بالفشل، والخطأ يعني أن الحالة لم تُحفظ
+func failJob(jobs *logic.JobStore, id string, cause error) error {
+	if _, err := jobs.Fail(id, cause); err != nil {
+		log.Printf("⚠️ failed to update job %s: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+// jobProgressReader يسجل نسبة البايتات المقروءة في المهمة
+type jobProgressReader struct {
+	r     io.Reader
+	total int64
+	read  int64
+	last  time.Time
+
+	jobs *logic.JobStore
+	id   string
+}
+
+func (p *jobProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	// لا نكتب ملف المهمة في كل قراءة
+	if now := time.Now(); p.total > 0 && now.Sub(p.last) >= jobProgressInterval {
+		p.last = now
+		// 100% تُسجل فقط عند نجاح المعالجة
+		percent := int(p.read * 100 / p.total)
+		if _, err := p.jobs.SetProgress(p.id, min(percent, 99)); err != nil {
+			log.Printf("⚠️ failed to update job %s: %v", p.id, err)
+		}
 	}
 
-	log.Printf("✅ PCAP processed successfully: %s", event.FileName)
+	return n, err
 }
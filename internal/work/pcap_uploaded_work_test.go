@@ -0,0 +1,52 @@
+package work
+
+import (
+	"LM-Gate/internal/events"
+	"LM-Gate/internal/logic"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnPcapUploadedSkipsFinishedJob(t *testing.T) {
+	jobs := logic.NewJobStore(t.TempDir())
+	if _, err := jobs.Create(&logic.Job{ID: "job", FileName: "cap.pcap", Path: "missing.pcap"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.Succeed("job", []string{"chunk_0.pcap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := OnPcapUploaded(jobs, events.PcapUploadedEvent{JobID: "job", FileName: "cap.pcap", Path: "missing.pcap"})
+	if err != nil {
+		t.Fatalf("duplicate event must be acknowledged: %v", err)
+	}
+
+	job, err := jobs.Get("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != logic.JobSucceeded {
+		t.Fatalf("finished job reprocessed: %+v", job)
+	}
+}
+
+func TestOnPcapUploadedFailsJob(t *testing.T) {
+	jobs := logic.NewJobStore(t.TempDir())
+	path := filepath.Join(t.TempDir(), "missing.pcap")
+	if _, err := jobs.Create(&logic.Job{ID: "job", FileName: "cap.pcap", Path: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	// الملف غير موجود: المهمة تصل إلى failed والرسالة تُؤكد
+	if err := OnPcapUploaded(jobs, events.PcapUploadedEvent{JobID: "job", FileName: "cap.pcap", Path: path}); err != nil {
+		t.Fatalf("failed job must be acknowledged: %v", err)
+	}
+
+	job, err := jobs.Get("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != logic.JobFailed || job.Error == "" {
+		t.Fatalf("got %+v, want a failed job", job)
+	}
+}
@@ -0,0 +1,371 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/events"
+	"LM-Gate/internal/infra"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// AbandonedQueueName is the RabbitMQ queue that carries
+	// events.UploadAbandonedEvent values.
+	AbandonedQueueName = "upload_abandoned_queue"
+
+	// DefaultUploadTTL is how long an upload may stay inactive
+	// before the Janitor removes it.
+	DefaultUploadTTL = 24 * time.Hour
+
+	// DefaultJanitorInterval is how often the Janitor sweeps.
+	DefaultJanitorInterval = 10 * time.Minute
+)
+
+// Resumable HTTP uploads, written by the API server:
+// <ResumableDir>/<id>/upload.json + data.
+//
+// NOTE: Same layout and lock file as internal/logic/resumable.go.
+const (
+	resumableSubdir    = "temp_chunks"
+	resumableStateFile = "upload.json"
+	resumableLockFile  = "upload.lock"
+)
+
+// Janitor removes abandoned partial uploads.
+//
+// An upload is abandoned when it never sent EOF and had no activity
+// for TTL. Activity is the manifest UpdatedAt on disk and the
+// uploads:activity score in Redis. Both stores are cleaned for every
+// abandoned FileID, and an UploadAbandonedEvent is published.
+// Resumable HTTP uploads in ResumableDir expire the same way.
+//
+// NOTE: Redis, Publish and ResumableDir are optional.
+type Janitor struct {
+	Chunks   *ChunkManager
+	Redis    *infra.RedisService
+	TTL      time.Duration
+	Interval time.Duration
+
+	// ResumableDir holds the resumable HTTP uploads
+	// (default <UploadDir>/temp_chunks, empty = not swept).
+	ResumableDir string
+
+	// Publish sends the abandoned event (e.g. to AbandonedQueueName).
+	Publish func(events.UploadAbandonedEvent) error
+}
+
+// NewJanitor creates a janitor with the default TTL and interval.
+func NewJanitor(chunks *ChunkManager, redis *infra.RedisService) *Janitor {
+	return &Janitor{
+		Chunks:       chunks,
+		Redis:        redis,
+		TTL:          DefaultUploadTTL,
+		Interval:     DefaultJanitorInterval,
+		ResumableDir: filepath.Join(chunks.UploadDir, resumableSubdir),
+	}
+}
+
+// JanitorFromEnv reads LM_UPLOAD_TTL and LM_JANITOR_INTERVAL
+// (Go durations such as "6h" or "15m") and LM_RESUMABLE_DIR.
+func JanitorFromEnv(chunks *ChunkManager, redis *infra.RedisService) (*Janitor, error) {
+	j := NewJanitor(chunks, redis)
+	j.ResumableDir = envOr("LM_RESUMABLE_DIR", j.ResumableDir)
+
+	for name, target := range map[string]*time.Duration{
+		"LM_UPLOAD_TTL":       &j.TTL,
+		"LM_JANITOR_INTERVAL": &j.Interval,
+	} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s: %q", name, v)
+		}
+		*target = d
+	}
+
+	return j, nil
+}
+
+// PublishTo returns a Publish function for a RabbitMQ client.
+func PublishTo(rabbit *infra.RabbitClient, queueName string) func(events.UploadAbandonedEvent) error {
+	return func(event events.UploadAbandonedEvent) error {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return rabbit.PublishMessage(queueName, string(body))
+	}
+}
+
+// Run sweeps every Interval until ctx is cancelled.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Sweep(time.Now()); err != nil {
+			log.Printf("Janitor sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep removes every upload inactive since now-TTL
+// and returns the published events.
+//
+// NOTE: A failure on one upload does not stop the others.
+// Every upload is checked again under its lock before it is removed,
+// so activity after the scan keeps it alive.
+func (j *Janitor) Sweep(now time.Time) ([]events.UploadAbandonedEvent, error) {
+	cutoff := now.Add(-j.TTL)
+	found := make(map[string]*events.UploadAbandonedEvent)
+
+	if err := j.staleOnDisk(cutoff, found); err != nil {
+		return nil, err
+	}
+
+	if j.Redis != nil {
+		stale, err := j.Redis.StaleUploads(cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		for id, last := range stale {
+			event, ok := found[id]
+			if !ok {
+				event = &events.UploadAbandonedEvent{FileID: id, LastActivity: last}
+				found[id] = event
+			}
+			if last.After(event.LastActivity) {
+				event.LastActivity = last
+			}
+			if n, err := j.Redis.ChunkCount(id); err == nil && int(n) > event.Received {
+				event.Received = int(n)
+			}
+			event.Stores = append(event.Stores, "redis")
+		}
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var expired []events.UploadAbandonedEvent
+	for _, id := range ids {
+		// نشاط حديث على القرص (تحت القفل) يعني أن الرفع ما زال حياً
+		removed, err := j.Chunks.CleanupStale(id, cutoff)
+		if err != nil {
+			log.Printf("Janitor: skipping %s: %v", id, err)
+			continue
+		}
+		if !removed {
+			continue
+		}
+		if j.Redis != nil {
+			j.Redis.ClearFile(id)
+		}
+
+		expired = append(expired, j.expire(*found[id]))
+	}
+
+	if j.ResumableDir != "" {
+		resumable, err := j.sweepResumable(cutoff)
+		if err != nil {
+			return expired, fmt.Errorf("resumable: %w", err)
+		}
+		expired = append(expired, resumable...)
+	}
+
+	return expired, nil
+}
+
+// expire logs and publishes the event of a removed upload.
+func (j *Janitor) expire(event events.UploadAbandonedEvent) events.UploadAbandonedEvent {
+	log.Printf("Upload abandoned: %s (%d chunks, last activity %s)", event.FileID, event.Received, event.LastActivity.Format(time.RFC3339))
+
+	if j.Publish != nil {
+		if err := j.Publish(event); err != nil {
+			log.Printf("Failed to publish abandoned event for %s: %v", event.FileID, err)
+		}
+	}
+	return event
+}
+
+// staleOnDisk adds the chunk directories inactive since cutoff.
+func (j *Janitor) staleOnDisk(cutoff time.Time, found map[string]*events.UploadAbandonedEvent) error {
+	entries, err := j.Chunks.FS.ReadDir(j.Chunks.TempDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || !validFileID(id) {
+			continue
+		}
+
+		manifest, err := j.Chunks.LoadManifest(id)
+		if err != nil {
+			log.Printf("Janitor: skipping %s: %v", id, err)
+			continue
+		}
+
+		// مجلد بدون manifest (أو manifest قديم): نستخدم وقت التعديل
+		last := manifest.UpdatedAt
+		if last.IsZero() {
+			last = entry.ModTime()
+		}
+		if last.After(cutoff) {
+			continue
+		}
+
+		found[id] = &events.UploadAbandonedEvent{
+			FileID:       id,
+			Uploader:     manifest.Uploader,
+			Received:     len(manifest.Chunks),
+			Total:        manifest.Total,
+			LastActivity: last.UTC(),
+			Stores:       []string{"disk"},
+		}
+	}
+
+	return nil
+}
+
+// resumableState is the part of the resumable upload.json the Janitor reads.
+type resumableState struct {
+	Uploader  string    `json:"uploader"`
+	CreatedAt time.Time `json:"created_at"`
+	Path      string    `json:"path,omitempty"` // set once the upload completed
+}
+
+// sweepResumable removes the resumable uploads inactive since cutoff.
+//
+// NOTE: A completed upload only leaves its state behind (the capture was
+// moved to the upload directory), so it is removed without an event.
+func (j *Janitor) sweepResumable(cutoff time.Time) ([]events.UploadAbandonedEvent, error) {
+	entries, err := j.Chunks.FS.ReadDir(j.ResumableDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []events.UploadAbandonedEvent
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// فحص أولي بدون قفل: رفع نشط لا ينتظر انتهاء الـ PATCH الجاري
+		dir := filepath.Join(j.ResumableDir, entry.Name())
+		if _, last := j.resumableActivity(dir, entry.ModTime()); last.After(cutoff) {
+			continue
+		}
+
+		event, err := j.removeResumable(dir, entry.ModTime(), cutoff)
+		if err != nil {
+			log.Printf("Janitor: skipping resumable upload %s: %v", entry.Name(), err)
+			continue
+		}
+		if event != nil {
+			expired = append(expired, j.expire(*event))
+		}
+	}
+
+	return expired, nil
+}
+
+// removeResumable checks a resumable upload again under its lock file
+// and removes it when it is still inactive.
+//
+// NOTE: The event is nil when nothing was removed or the upload had completed.
+func (j *Janitor) removeResumable(dir string, dirTime time.Time, cutoff time.Time) (*events.UploadAbandonedEvent, error) {
+	unlock, err := j.Chunks.FS.Lock(filepath.Join(dir, resumableLockFile))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state, last := j.resumableActivity(dir, dirTime)
+	if last.After(cutoff) {
+		return nil, nil
+	}
+
+	if err := j.Chunks.FS.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	j.Chunks.Quota.Release(dir)
+
+	if state.Path != "" {
+		log.Printf("Resumable upload state expired: %s", filepath.Base(dir))
+		return nil, nil
+	}
+
+	return &events.UploadAbandonedEvent{
+		FileID:       filepath.Base(dir),
+		Uploader:     state.Uploader,
+		LastActivity: last.UTC(),
+		Stores:       []string{"resumable"},
+	}, nil
+}
+
+// resumableActivity returns the state of a resumable upload and its last
+// activity: CreatedAt or the newest file in dir (dirTime when it is empty).
+//
+// NOTE: The lock file is ignored, taking the lock creates it.
+func (j *Janitor) resumableActivity(dir string, dirTime time.Time) (resumableState, time.Time) {
+	var state resumableState
+	if data, err := j.Chunks.FS.ReadFile(filepath.Join(dir, resumableStateFile)); err == nil {
+		// حالة تالفة: يُحكم على الرفع بوقت تعديل ملفاته فقط
+		json.Unmarshal(data, &state)
+	}
+
+	last := newestModTime(j.Chunks.FS, dir, resumableLockFile)
+	if last.IsZero() {
+		last = dirTime
+	}
+	if state.CreatedAt.After(last) {
+		last = state.CreatedAt
+	}
+	return state, last
+}
+
+// newestModTime returns the newest modification time of the files in dir,
+// skipping the file named skip. An empty or missing dir returns the zero time.
+func newestModTime(fs infra.FileSystem, dir string, skip string) time.Time {
+	var newest time.Time
+
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return newest
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == skip {
+			continue
+		}
+		if entry.ModTime().After(newest) {
+			newest = entry.ModTime()
+		}
+	}
+	return newest
+}
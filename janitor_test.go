@@ -0,0 +1,147 @@
+package lmgate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"LM-Gate/internal/infra"
+)
+
+func TestJanitorSweepsStaleChunks(t *testing.T) {
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	if err := m.StoreChunk(BuildChunkMessage("abc", 0, 2, []byte("chunk"))); err != nil {
+		t.Fatal(err)
+	}
+
+	j := NewJanitor(m, nil)
+
+	expired, err := j.Sweep(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Fatalf("active upload removed: %+v", expired)
+	}
+
+	expired, err = j.Sweep(time.Now().Add(j.TTL + time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].FileID != "abc" || expired[0].Received != 1 {
+		t.Fatalf("got %+v, want one event for abc", expired)
+	}
+	if _, err := m.FS.Stat(m.chunkPath("abc", 0)); err == nil {
+		t.Fatal("abandoned chunk still on disk")
+	}
+}
+
+func TestCleanupStaleRechecksActivity(t *testing.T) {
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	cutoff := time.Now()
+
+	// قطعة وصلت بعد قرار الـ Janitor
+	if err := m.StoreChunk(BuildChunkMessage("abc", 0, 2, []byte("chunk"))); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := m.CleanupStale("abc", cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed {
+		t.Fatal("upload with activity after the cutoff removed")
+	}
+	if _, err := m.FS.Stat(m.chunkPath("abc", 0)); err != nil {
+		t.Fatalf("chunk removed: %v", err)
+	}
+
+	removed, err = m.CleanupStale("abc", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("stale upload kept")
+	}
+}
+
+func TestJanitorSweepsResumableUploads(t *testing.T) {
+	root := t.TempDir()
+	m := NewChunkManager(infra.NewLocalFileSystem(), filepath.Join(root, "tmp"), filepath.Join(root, "up"))
+	quota, err := infra.NewDiskQuota(m.FS, filepath.Join(m.UploadDir, infra.QuotaLedgerName), 0, 0, m.UploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Quota = quota
+	j := NewJanitor(m, nil)
+
+	old := time.Now().Add(-2 * j.TTL)
+	abandoned := writeResumable(t, j.ResumableDir, "abandoned", resumableState{Uploader: "sensor", CreatedAt: old}, old)
+	completed := writeResumable(t, j.ResumableDir, "completed", resumableState{Uploader: "sensor", CreatedAt: old, Path: "up/x.pcap"}, old)
+	active := writeResumable(t, j.ResumableDir, "active", resumableState{Uploader: "sensor", CreatedAt: old}, time.Now())
+
+	if err := m.Quota.Reserve("sensor", filepath.Join(abandoned, "data"), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := j.Sweep(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].FileID != "abandoned" || expired[0].Uploader != "sensor" {
+		t.Fatalf("got %+v, want one event for the abandoned upload", expired)
+	}
+	if len(expired[0].Stores) != 1 || expired[0].Stores[0] != "resumable" {
+		t.Fatalf("stores %v, want [resumable]", expired[0].Stores)
+	}
+
+	for _, dir := range []string{abandoned, completed} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("%s not removed: %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(active, "data")); err != nil {
+		t.Fatalf("active upload removed: %v", err)
+	}
+
+	usage, err := m.Quota.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Uploaders["sensor"] != 0 {
+		t.Fatalf("sensor still has %d bytes reserved", usage.Uploaders["sensor"])
+	}
+}
+
+// writeResumable ينشئ رفعاً قابلاً للاستكمال كما يكتبه الـ API server
+func writeResumable(t *testing.T, dir string, id string, state resumableState, modTime time.Time) string {
+	t.Helper()
+
+	uploadDir := filepath.Join(dir, id)
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{resumableStateFile: data}
+	if state.Path == "" {
+		files["data"] = []byte("partial")
+	}
+
+	for name, content := range files {
+		path := filepath.Join(uploadDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return uploadDir
+}
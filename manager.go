@@ -0,0 +1,120 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/infra"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Default storage roots, relative to the working directory.
+//
+// NOTE: Override with LM_TEMP_CHUNKS_DIR and LM_UPLOADS_DIR
+// (e.g. /data/temp_chunks and /data/uploads inside Docker).
+const (
+	DefaultTempDir   = "temp_chunks"
+	DefaultUploadDir = "uploads"
+)
+
+// ChunkManager owns chunk ingestion: storing chunks, tracking manifests,
+// reassembling files and cleaning temporary data.
+//
+// NOTE: All file access goes through FS, so the same code runs against
+// the local disk, the Docker volume or an in-memory filesystem.
+type ChunkManager struct {
+	FS        infra.FileSystem
+	TempDir   string // chunk directories: <TempDir>/<FileID>/part_N
+	UploadDir string // assembled captures: <UploadDir>/<FileID>.pcap
+
+	// Quota is optional, nil means no disk limit.
+	Quota *infra.DiskQuota
+
+	// Keyring decrypts encrypted chunks, nil means none configured.
+	Keyring *Keyring
+
+	mu sync.Mutex // serializes manifest and capture metadata updates
+}
+
+// NewChunkManager creates a manager over the given filesystem and roots.
+func NewChunkManager(fs infra.FileSystem, tempDir string, uploadDir string) *ChunkManager {
+	return &ChunkManager{
+		FS:        fs,
+		TempDir:   tempDir,
+		UploadDir: uploadDir,
+	}
+}
+
+// NewChunkManagerFromEnv creates a manager on the local disk.
+//
+// NOTE: Roots come from LM_TEMP_CHUNKS_DIR / LM_UPLOADS_DIR,
+// the quota from LM_QUOTA_* and the keyring from LM_ENCRYPTION_*.
+func NewChunkManagerFromEnv() (*ChunkManager, error) {
+	tempDir := envOr("LM_TEMP_CHUNKS_DIR", DefaultTempDir)
+	uploadDir := envOr("LM_UPLOADS_DIR", DefaultUploadDir)
+
+	m := NewChunkManager(infra.NewLocalFileSystem(), tempDir, uploadDir)
+
+	quota, err := infra.QuotaFromEnv(m.FS, filepath.Join(uploadDir, infra.QuotaLedgerName), tempDir, uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	m.Quota = quota
+
+	keyring, err := LoadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	m.Keyring = keyring
+
+	return m, nil
+}
+
+// defaultManager backs the package-level helpers (StoreChunk, AssembleFile, ...).
+var defaultManager = sync.OnceValues(NewChunkManagerFromEnv)
+
+// DefaultChunkManager returns the manager configured from the environment.
+func DefaultChunkManager() (*ChunkManager, error) {
+	return defaultManager()
+}
+
+// chunkDir returns the temp directory of a file.
+func (m *ChunkManager) chunkDir(fileID string) string {
+	return filepath.Join(m.TempDir, fileID)
+}
+
+// chunkPath returns where one chunk is stored.
+func (m *ChunkManager) chunkPath(fileID string, chunkID int) string {
+	return filepath.Join(m.chunkDir(fileID), fmt.Sprintf("part_%d", chunkID))
+}
+
+// manifestPath returns the manifest location for a file.
+func (m *ChunkManager) manifestPath(fileID string) string {
+	return filepath.Join(m.chunkDir(fileID), manifestFile)
+}
+
+// capturePath returns where the assembled capture of a file is stored.
+func (m *ChunkManager) capturePath(fileID string) string {
+	return filepath.Join(m.UploadDir, fileID+".pcap")
+}
+
+// captureInfoPath returns where the capture metadata is stored.
+func (m *ChunkManager) captureInfoPath(fileID string) string {
+	return filepath.Join(m.UploadDir, fileID+".json")
+}
+
+// validFileID rejects IDs that could escape the storage roots.
+func validFileID(fileID string) bool {
+	return fileID != "" &&
+		fileID != "." &&
+		fileID != ".." &&
+		!strings.ContainsAny(fileID, `/\`)
+}
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
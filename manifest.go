@@ -0,0 +1,220 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/infra"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ManifestQueueName is the RabbitMQ queue used to ask the server
+// which chunks it already has.
+const ManifestQueueName = "chunk_manifest_queue"
+
+// manifestFile is the name of the manifest inside temp_chunks/<FileID>.
+const manifestFile = "manifest.json"
+
+// ChunkManifest records which chunks the server already received for a file.
+//
+// NOTE: The same structure is used on both client and server.
+type ChunkManifest struct {
+	FileID     string            `json:"file_id"`
+	Total      int               `json:"total"`
+	FileDigest string            `json:"file_digest,omitempty"` // set by the EOF message
+	Uploader   string            `json:"uploader,omitempty"`
+	Chunks     map[int]ChunkInfo `json:"chunks"`
+	UpdatedAt  time.Time         `json:"updated_at"` // last activity, used by the Janitor
+}
+
+// ChunkInfo describes one received chunk.
+type ChunkInfo struct {
+	Size        int64  `json:"size"` // stored (possibly compressed or encrypted) size
+	Checksum    string `json:"checksum"`
+	RawChecksum string `json:"raw_checksum"` // keyed when encrypted (FileKey.Checksum)
+	Codec       string `json:"codec,omitempty"`
+	KeyID       string `json:"key_id,omitempty"`
+	WrappedKey  []byte `json:"wrapped_key,omitempty"`
+}
+
+// NewChunkManifest creates an empty manifest for a file.
+func NewChunkManifest(fileID string) *ChunkManifest {
+	return &ChunkManifest{
+		FileID: fileID,
+		Chunks: make(map[int]ChunkInfo),
+	}
+}
+
+// Has reports whether a chunk with the given ID and original
+// content checksum (RawChecksum) was received.
+func (m *ChunkManifest) Has(chunkID int, rawChecksum string) bool {
+	if m == nil {
+		return false
+	}
+	got, ok := m.Chunks[chunkID]
+	return ok && got.RawChecksum == rawChecksum
+}
+
+// Missing returns the sorted IDs of chunks that were not received yet.
+//
+// NOTE: Returns nil when Total is unknown.
+func (m *ChunkManifest) Missing() []int {
+	var missing []int
+	for i := 0; i < m.Total; i++ {
+		if _, ok := m.Chunks[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// ReceivedIDs returns the sorted IDs of received chunks.
+func (m *ChunkManifest) ReceivedIDs() []int {
+	ids := make([]int, 0, len(m.Chunks))
+	for id := range m.Chunks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// ================= Server side =================
+
+// LoadManifest reads the manifest of a file.
+//
+// NOTE: A file with no stored chunks returns an empty manifest.
+func (m *ChunkManager) LoadManifest(fileID string) (*ChunkManifest, error) {
+	if !validFileID(fileID) {
+		return nil, ErrInvalidMessage
+	}
+
+	data, err := m.FS.ReadFile(m.manifestPath(fileID))
+	if errors.Is(err, os.ErrNotExist) {
+		return NewChunkManifest(fileID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := NewChunkManifest(fileID)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("corrupted manifest for %s: %w", fileID, err)
+	}
+	if manifest.Chunks == nil {
+		manifest.Chunks = make(map[int]ChunkInfo)
+	}
+
+	return manifest, nil
+}
+
+// LoadManifest reads a manifest using the default manager.
+func LoadManifest(fileID string) (*ChunkManifest, error) {
+	m, err := defaultManager()
+	if err != nil {
+		return nil, err
+	}
+	return m.LoadManifest(fileID)
+}
+
+// recordChunk adds a stored chunk to the file manifest.
+//
+// NOTE: The caller holds m.mu (see StoreChunk).
+func (m *ChunkManager) recordChunk(msg ChunkMessage) error {
+	return m.updateManifestLocked(msg.FileID, func(manifest *ChunkManifest) error {
+		manifest.Chunks[msg.ChunkID] = ChunkInfo{
+			Size:        int64(len(msg.Data)),
+			Checksum:    msg.Checksum,
+			RawChecksum: msg.RawChecksum,
+			Codec:       msg.Codec,
+			KeyID:       msg.KeyID,
+			WrappedKey:  msg.WrappedKey,
+		}
+		if msg.Total > 0 {
+			manifest.Total = msg.Total
+		}
+		if manifest.Uploader == "" {
+			manifest.Uploader = msg.Uploader
+		}
+		return nil
+	})
+}
+
+// recordEOF stores the whole-file digest and the final Total
+// sent with the EOF message.
+//
+// NOTE: Streamed and adaptive chunks carry Total 0, the EOF message is
+// the first one that knows it, so its Total wins. EOF is only sent after
+// every chunk was confirmed, so chunks beyond it are left over from an
+// earlier attempt and are dropped. The file digest still guards the result.
+// Only the uploader of the chunks may end the file, like in StoreChunk.
+func (m *ChunkManager) recordEOF(msg ChunkMessage) error {
+	return m.updateManifest(msg.FileID, func(manifest *ChunkManifest) error {
+		if manifest.Uploader != "" && manifest.Uploader != msg.Uploader {
+			return fmt.Errorf("%w: EOF of %s sent by another uploader", ErrInvalidMessage, msg.FileID)
+		}
+
+		if msg.Total > 0 {
+			for id := range manifest.Chunks {
+				if id >= msg.Total {
+					delete(manifest.Chunks, id)
+				}
+			}
+			manifest.Total = msg.Total
+		}
+		manifest.FileDigest = msg.FileDigest
+		return nil
+	})
+}
+
+// updateManifest loads, changes and saves a manifest.
+//
+// NOTE: The manifest is written to a temp file and renamed,
+// so a crash never leaves a half-written manifest behind.
+func (m *ChunkManager) updateManifest(fileID string, change func(manifest *ChunkManifest) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.updateManifestLocked(fileID, change)
+}
+
+// updateManifestLocked is updateManifest for callers that hold m.mu.
+func (m *ChunkManager) updateManifestLocked(fileID string, change func(manifest *ChunkManifest) error) error {
+	manifest, err := m.LoadManifest(fileID)
+	if err != nil {
+		return err
+	}
+
+	if err := change(manifest); err != nil {
+		return err
+	}
+	manifest.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	path := m.manifestPath(fileID)
+	tmp := path + ".tmp"
+	if err := m.FS.WriteFile(tmp, data); err != nil {
+		return err
+	}
+
+	return m.FS.Rename(tmp, path)
+}
+
+// ServeManifests answers manifest queries from clients over RabbitMQ.
+//
+// NOTE: The request body is the FileID, the reply is the manifest as JSON.
+func (m *ChunkManager) ServeManifests(rabbit *infra.RabbitClient) error {
+	return rabbit.ServeRPC(ManifestQueueName, func(body []byte) ([]byte, error) {
+		manifest, err := m.LoadManifest(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(manifest)
+	})
+}
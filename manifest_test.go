@@ -0,0 +1,69 @@
+package lmgate
+
+import (
+	"reflect"
+	"testing"
+
+	"LM-Gate/internal/infra"
+)
+
+func TestManifestMissingAndHas(t *testing.T) {
+	m := NewChunkManifest("abc")
+	m.Total = 4
+	m.Chunks[0] = ChunkInfo{RawChecksum: "r0"}
+	m.Chunks[2] = ChunkInfo{RawChecksum: "r2"}
+
+	if got := m.Missing(); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Fatalf("Missing() = %v, want [1 3]", got)
+	}
+	if got := m.ReceivedIDs(); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Fatalf("ReceivedIDs() = %v, want [0 2]", got)
+	}
+
+	if !m.Has(2, "r2") {
+		t.Fatal("chunk 2 with the same checksum must be found")
+	}
+	if m.Has(2, "other") || m.Has(1, "") {
+		t.Fatal("changed or missing chunk reported as received")
+	}
+	if (*ChunkManifest)(nil).Has(0, "r0") {
+		t.Fatal("nil manifest must have no chunks")
+	}
+}
+
+func TestStoreChunkRecordsManifest(t *testing.T) {
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+
+	data := []byte("chunk data")
+	msg := BuildChunkMessage("abc", 1, 3, data)
+	msg.Uploader = "sensor-1"
+	if err := m.StoreChunk(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := m.LoadManifest("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Total != 3 || manifest.Uploader != "sensor-1" {
+		t.Fatalf("got total %d uploader %q", manifest.Total, manifest.Uploader)
+	}
+	if !manifest.Has(1, ChunkChecksum(data)) {
+		t.Fatal("stored chunk missing from the manifest")
+	}
+
+	missing, err := m.MissingChunks("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, []int{0, 2}) {
+		t.Fatalf("MissingChunks() = %v, want [0 2]", missing)
+	}
+}
+
+func TestLoadManifestRejectsBadFileID(t *testing.T) {
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	if _, err := m.LoadManifest("../etc"); err == nil {
+		t.Fatal("path traversal file ID accepted")
+	}
+}
@@ -0,0 +1,101 @@
+package lmgate
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"LM-Gate/internal/infra"
+)
+
+func newQuotaManager(t *testing.T, perUploader int64) *ChunkManager {
+	t.Helper()
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	quota, err := infra.NewDiskQuota(m.FS, filepath.Join(m.UploadDir, infra.QuotaLedgerName), 0, perUploader, m.TempDir, m.UploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Quota = quota
+	return m
+}
+
+func TestChunkUploaderIgnoresClientField(t *testing.T) {
+	if got := ChunkUploader("sensor1"); got != "amqp-sensor1" {
+		t.Fatalf("got %q", got)
+	}
+	if got := ChunkUploader(""); got != "anonymous" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestStoreChunkRejectsOtherUploader(t *testing.T) {
+	m := newQuotaManager(t, 0)
+
+	first := BuildChunkMessage("abc", 0, 2, []byte("one"))
+	first.Uploader = "amqp-sensor1"
+	if err := m.StoreChunk(first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := BuildChunkMessage("abc", 1, 2, []byte("two"))
+	second.Uploader = "amqp-sensor2"
+	if err := m.StoreChunk(second); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("got %v, want ErrInvalidMessage", err)
+	}
+}
+
+func TestEOFRejectsOtherUploader(t *testing.T) {
+	m := newQuotaManager(t, 0)
+
+	for id, data := range []string{"one", "two"} {
+		msg := BuildChunkMessage("abc", id, 2, []byte(data))
+		msg.Uploader = "amqp-sensor1"
+		if err := m.StoreChunk(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// مستخدم آخر يحاول إنهاء الملف بعد القطعة الأولى
+	eof := ChunkMessage{FileID: "abc", Total: 1, IsEOF: true, FileDigest: "digest", Uploader: "amqp-sensor2"}
+	if _, err := m.OnMessage(eof); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("got %v, want ErrInvalidMessage", err)
+	}
+
+	manifest, err := m.LoadManifest("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Total != 2 || len(manifest.Chunks) != 2 || manifest.FileDigest != "" {
+		t.Fatalf("manifest changed: total %d, %d chunks, digest %q", manifest.Total, len(manifest.Chunks), manifest.FileDigest)
+	}
+	if _, err := m.FS.Stat(m.chunkPath("abc", 1)); err != nil {
+		t.Fatalf("chunk removed: %v", err)
+	}
+}
+
+func TestAssembleFileReleasesReservationOnFailure(t *testing.T) {
+	m := newQuotaManager(t, 0)
+	data := []byte("capture bytes")
+
+	msg := BuildChunkMessage("abc", 0, 1, data)
+	msg.Uploader = "amqp-sensor1"
+	if err := m.StoreChunk(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.recordEOF(ChunkMessage{FileID: "abc", Total: 1, IsEOF: true, FileDigest: "wrong", Uploader: "amqp-sensor1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// digest خاطئ: لا يبقى حجز للملف النهائي
+	var mismatch *DigestMismatchError
+	if _, err := m.AssembleFile("abc"); !errors.As(err, &mismatch) {
+		t.Fatalf("got %v, want DigestMismatchError", err)
+	}
+	usage, err := m.Quota.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := usage.Uploaders["amqp-sensor1"]; got != int64(len(data)) {
+		t.Fatalf("sensor1 has %d bytes after a failed assembly, want only the chunk (%d)", got, len(data))
+	}
+}
@@ -1,22 +1,192 @@
 package lmgate
 
 import (
+	"LM-Gate/internal/events"
+	"LM-Gate/internal/infra"
+	"LM-Gate/internal/logic"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"hash"
+	"io"
+	"log"
+	"time"
 )
 
+// ReceiveChunks consumes chunk messages from RabbitMQ
+// and passes each one to OnMessage.
+//
+// NOTE: A message is acknowledged only after it was stored.
+// When a file is assembled, a PcapUploadedEvent is published
+// so the worker can process the capture.
+// Corrupted or forged messages are dead-lettered at once; other
+// failures (disk, quota, chunks still in the retry queue) are retried.
+func (m *ChunkManager) ReceiveChunks(rabbit *infra.RabbitClient, queueName string) error {
+	return rabbit.ConsumeWithSender(queueName, func(body []byte, sender string) error {
+		msg, err := DecodeChunkMessage(body)
+		if err != nil {
+			return infra.Permanent(fmt.Errorf("%w: %w", ErrInvalidMessage, err))
+		}
+
+		// الحصة تُحسب على مستخدم RabbitMQ وليس على ما يرسله العميل
+		msg.Uploader = ChunkUploader(sender)
+
+		path, err := m.OnMessage(msg)
+		if permanentChunkError(err) {
+			return infra.Permanent(err)
+		}
+		if err != nil || path == "" {
+			return err
+		}
+
+		return m.publishUploaded(rabbit, path)
+	})
+}
+
+// ChunkUploader names the uploader of a chunk for quotas: the RabbitMQ
+// user that published it (validated by the broker), never the Uploader
+// field sent by the client.
+//
+// NOTE: Give every sensor its own RabbitMQ user to get per-sensor quotas.
+func ChunkUploader(sender string) string {
+	if sender == "" {
+		return "anonymous"
+	}
+	return "amqp-" + sender
+}
+
+// permanentChunkError reports errors that a redelivery cannot fix.
+func permanentChunkError(err error) bool {
+	var mismatch *DigestMismatchError
+	return errors.Is(err, ErrInvalidMessage) ||
+		errors.Is(err, ErrChecksumMismatch) ||
+		errors.Is(err, ErrDecryptFailed) ||
+		errors.Is(err, ErrUnknownKey) ||
+		errors.As(err, &mismatch)
+}
+
+// OnMessage is the main server entry point.
+// It receives chunk messages and decides what to do.
+//
+// NOTE: Returns the assembled file path when the EOF message
+// completes a file, and an empty path otherwise.
+func (m *ChunkManager) OnMessage(msg ChunkMessage) (string, error) {
+	if err := ValidateMessage(msg); err != nil {
+		return "", err
+	}
+
+	if !msg.IsEOF {
+		return "", m.StoreChunk(msg)
+	}
+
+	// EOF أُعيد إرساله بعد أن تم التجميع (مثلاً فشل نشر الحدث)
+	if path, ok := m.assembledBefore(msg.FileID); ok {
+		return path, nil
+	}
+
+	if err := m.recordEOF(msg); err != nil {
+		return "", err
+	}
+
+	path, err := m.AssembleFile(msg.FileID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.linkCaptureName(msg.FileID, msg.FileName); err != nil {
+		return "", err
+	}
+
+	m.Cleanup(msg.FileID)
+	log.Printf("File assembled: %s", path)
+
+	return path, nil
+}
+
+// assembledBefore reports whether a file was already assembled
+// and its chunks cleaned up, so a redelivered EOF is not an error.
+func (m *ChunkManager) assembledBefore(fileID string) (string, bool) {
+	manifest, err := m.LoadManifest(fileID)
+	if err != nil || manifest.Total > 0 {
+		return "", false
+	}
+
+	path := m.capturePath(fileID)
+	if _, err := m.FS.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// OnMessage handles a chunk message using the default manager.
+func OnMessage(msg ChunkMessage) (string, error) {
+	m, err := defaultManager()
+	if err != nil {
+		return "", err
+	}
+	return m.OnMessage(msg)
+}
+
+// publishUploaded tells the worker that a full capture is ready.
+func (m *ChunkManager) publishUploaded(rabbit *infra.RabbitClient, path string) error {
+	info, err := m.FS.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(events.PcapUploadedEvent{
+		FileName: info.Name(),
+		Path:     path,
+		Size:     info.Size(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return rabbit.PublishMessage(logic.QueueName, string(body))
+}
+
 // ValidateMessage performs basic validation on incoming messages.
 //
 // NOTE: This prevents invalid or corrupted messages from being processed.
-// TODO: Add validation for ChunkID range.
+// FileID is used as a directory name, so path separators are rejected.
+// Data chunks must carry a ChunkID inside [0, Total). Total 0 means
+// a stream of unknown length; its Total arrives with the EOF message,
+// which must announce at least one chunk and carry the file digest.
 func ValidateMessage(msg ChunkMessage) error {
-	if msg.FileID == "" {
+	if !validFileID(msg.FileID) {
 		return ErrInvalidMessage
 	}
 
-	if !msg.IsEOF && len(msg.Data) == 0 {
-		return ErrInvalidMessage
+	// EOF بدون قطع: ملف فارغ لا يمكن تجميعه
+	if msg.IsEOF {
+		if msg.Total <= 0 {
+			return fmt.Errorf("%w: EOF without chunks (empty file)", ErrInvalidMessage)
+		}
+		// بدون digest لا يمكن التحقق من الملف المجمّع
+		if msg.FileDigest == "" {
+			return fmt.Errorf("%w: EOF without file digest", ErrInvalidMessage)
+		}
+		return nil
+	}
+
+	if !isKnownCodec(msg.Codec) {
+		return fmt.Errorf("%w: %w %q", ErrInvalidMessage, ErrUnknownCodec, msg.Codec)
+	}
+
+	// القطعة المشفرة أكبر من الأصل بـ nonce و tag فقط
+	if len(msg.Data) == 0 || len(msg.Data) > MaxChunkSize+sealOverhead {
+		return fmt.Errorf("%w: chunk size %d", ErrInvalidMessage, len(msg.Data))
+	}
+
+	if msg.KeyID != "" && len(msg.WrappedKey) == 0 {
+		return fmt.Errorf("%w: encrypted chunk without wrapped key", ErrInvalidMessage)
+	}
+
+	if msg.Total < 0 || msg.ChunkID < 0 || (msg.Total > 0 && msg.ChunkID >= msg.Total) {
+		return fmt.Errorf("%w: chunk %d out of range (total %d)", ErrInvalidMessage, msg.ChunkID, msg.Total)
 	}
 
 	return nil
@@ -25,69 +195,365 @@ func ValidateMessage(msg ChunkMessage) error {
 // StoreChunk saves a file chunk to disk.
 //
 // NOTE: Chunks are stored on disk to avoid high memory usage.
-// TODO: Add checksum validation per chunk.
-// FIXME: No limit on disk usage is enforced.
-func StoreChunk(msg ChunkMessage) error {
-	tempDir := fmt.Sprintf("temp_chunks/%s", msg.FileID)
+// Every stored chunk is recorded in the file manifest.
+// Chunks whose data does not match Checksum are rejected.
+// Duplicate chunks overwrite the previous copy, so resending is safe.
+// Chunks that would exceed the disk quota are refused.
+// The chunk is written and recorded under m.mu, so the Janitor never
+// removes a directory between the two.
+func (m *ChunkManager) StoreChunk(msg ChunkMessage) error {
+	if msg.Checksum != ChunkChecksum(msg.Data) {
+		return fmt.Errorf("%w: file=%s chunk=%d", ErrChecksumMismatch, msg.FileID, msg.ChunkID)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	manifest, err := m.LoadManifest(msg.FileID)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Total > 0 && msg.Total > 0 && manifest.Total != msg.Total {
+		return fmt.Errorf(
+			"%w: total changed from %d to %d for %s",
+			ErrInvalidMessage,
+			manifest.Total,
+			msg.Total,
+			msg.FileID,
+		)
+	}
+
+	// قطع الملف الواحد يجب أن تأتي من نفس المستخدم
+	if manifest.Uploader != "" && manifest.Uploader != msg.Uploader {
+		return fmt.Errorf("%w: chunk of %s sent by another uploader", ErrInvalidMessage, msg.FileID)
+	}
+
+	if err := m.FS.MkdirAll(m.chunkDir(msg.FileID)); err != nil {
+		return err
+	}
+
+	chunkPath := m.chunkPath(msg.FileID, msg.ChunkID)
+
+	if err := m.Quota.Reserve(msg.Uploader, chunkPath, int64(len(msg.Data))); err != nil {
+		return err
+	}
 
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+	if err := m.FS.WriteFile(chunkPath, msg.Data); err != nil {
 		return err
 	}
 
-	chunkPath := filepath.Join(tempDir, fmt.Sprintf("part_%d", msg.ChunkID))
+	// تسجيل القطعة في الـ manifest حتى يستطيع العميل استكمال الرفع
+	return m.recordChunk(msg)
+}
 
-	return os.WriteFile(chunkPath, msg.Data, 0644)
+// StoreChunk saves a chunk using the default manager.
+func StoreChunk(msg ChunkMessage) error {
+	m, err := defaultManager()
+	if err != nil {
+		return err
+	}
+	return m.StoreChunk(msg)
 }
 
-// IsFileComplete checks if there are stored chunks for a file.
+// IsFileComplete checks if every expected chunk of a file was stored.
 //
-// NOTE: This only checks if chunks exist, not if all chunks arrived.
-// FIXME: This does not verify the expected number of chunks.
+// NOTE: The expected number of chunks comes from ChunkMessage.Total
+// (for streams, from the EOF message).
+func (m *ChunkManager) IsFileComplete(fileID string) bool {
+	missing, err := m.MissingChunks(fileID)
+	return err == nil && len(missing) == 0
+}
+
+// IsFileComplete checks a file using the default manager.
 func IsFileComplete(fileID string) bool {
-	tempDir := fmt.Sprintf("temp_chunks/%s", fileID)
-	files, err := os.ReadDir(tempDir)
+	m, err := defaultManager()
+	return err == nil && m.IsFileComplete(fileID)
+}
+
+// MissingChunks returns the sorted IDs of chunks that were not stored yet.
+//
+// NOTE: Returns ErrMissingChunk when no chunk arrived yet,
+// because the expected Total is still unknown.
+func (m *ChunkManager) MissingChunks(fileID string) ([]int, error) {
+	manifest, err := m.LoadManifest(fileID)
 	if err != nil {
-		return false
+		return nil, err
 	}
 
-	return len(files) > 0
+	if manifest.Total == 0 {
+		return nil, fmt.Errorf("%w: no chunks received for %s", ErrMissingChunk, fileID)
+	}
+
+	return manifest.Missing(), nil
+}
+
+// MissingChunks lists missing chunks using the default manager.
+func MissingChunks(fileID string) ([]int, error) {
+	m, err := defaultManager()
+	if err != nil {
+		return nil, err
+	}
+	return m.MissingChunks(fileID)
+}
+
+// MissingChunksError is returned when a file cannot be assembled
+// because some chunks have not arrived.
+type MissingChunksError struct {
+	FileID  string
+	Total   int
+	Missing []int
+}
+
+func (e *MissingChunksError) Error() string {
+	return fmt.Sprintf(
+		"%s: %d of %d chunks missing: %v",
+		e.FileID,
+		len(e.Missing),
+		e.Total,
+		e.Missing,
+	)
+}
+
+// Unwrap allows errors.Is(err, ErrMissingChunk).
+func (e *MissingChunksError) Unwrap() error {
+	return ErrMissingChunk
+}
+
+// DigestMismatchError is returned when an assembled file does not
+// match the digest sent by the client.
+type DigestMismatchError struct {
+	FileID   string
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf(
+		"file digest mismatch for %s: expected %s, got %s",
+		e.FileID,
+		e.Expected,
+		e.Actual,
+	)
 }
 
 // AssembleFile rebuilds the original file from stored chunks.
 //
-// NOTE: Chunks are read sequentially: part_0, part_1, ...
-// FIXME: If a chunk is missing, the loop stops silently.
-// TODO: Detect missing chunks and return an error.
-func AssembleFile(fileID string) (string, error) {
-	tempDir := filepath.Join("temp_chunks", fileID)
-	finalDir := "uploads"
-	os.MkdirAll(finalDir, 0755)
+// NOTE: Chunks are read sequentially: part_0 ... part_<Total-1>.
+// Encrypted chunks are decrypted and compressed chunks are decoded here,
+// using the key and codec recorded in the manifest.
+// Nothing is written when chunks are missing (see MissingChunksError).
+// The file is written to a temp path and only moved into UploadDir
+// after its digest matches the one from the EOF message; a file
+// without a recorded digest is never moved.
+// Quota for the whole file is reserved before writing (the stored
+// chunk sizes as estimate) and set to the real size at the end.
+func (m *ChunkManager) AssembleFile(fileID string) (string, error) {
+	manifest, err := m.LoadManifest(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	if manifest.Total == 0 {
+		return "", fmt.Errorf("%w: no chunks received for %s", ErrMissingChunk, fileID)
+	}
+
+	if missing := manifest.Missing(); len(missing) > 0 {
+		return "", &MissingChunksError{
+			FileID:  fileID,
+			Total:   manifest.Total,
+			Missing: missing,
+		}
+	}
+
+	finalPath := m.capturePath(fileID)
+	if err := m.FS.MkdirAll(m.UploadDir); err != nil {
+		return "", err
+	}
+
+	partPath := finalPath + ".part"
+
+	digest, err := m.fileHash(manifest)
+	if err != nil {
+		return "", err
+	}
 
-	finalPath := filepath.Join(finalDir, fileID+".pcap")
+	var estimate int64
+	for _, info := range manifest.Chunks {
+		estimate += info.Size
+	}
 
-	out, err := os.Create(finalPath)
+	out, err := m.FS.Create(partPath)
 	if err != nil {
 		return "", err
 	}
+	defer m.FS.Remove(partPath)
 	defer out.Close()
 
+	reserved, err := m.Quota.NewQuotaWriter(manifest.Uploader, finalPath, estimate, out)
+	if err != nil {
+		return "", err
+	}
+	w := io.MultiWriter(reserved, digest)
+
+	// أي فشل قبل النقل يلغي الحجز
+	done := false
+	defer func() {
+		if !done {
+			m.Quota.Release(finalPath)
+		}
+	}()
+
 	// Merge chunks in order
-	for i := 0; ; i++ {
-		chunkPath := filepath.Join(tempDir, fmt.Sprintf("part_%d", i))
-		data, err := os.ReadFile(chunkPath)
+	for i := 0; i < manifest.Total; i++ {
+		data, err := m.FS.ReadFile(m.chunkPath(fileID, i))
+		if err != nil {
+			return "", fmt.Errorf("%w: chunk %d of %s: %v", ErrMissingChunk, i, fileID, err)
+		}
+
+		// القطعة على القرص يجب أن تطابق ما سُجّل عند الاستلام
+		info := manifest.Chunks[i]
+		if ChunkChecksum(data) != info.Checksum {
+			return "", fmt.Errorf("%w: file=%s chunk=%d", ErrChecksumMismatch, fileID, i)
+		}
+
+		// فك التشفير يتم هنا فقط، القطع تبقى مشفرة على القرص
+		if info.KeyID != "" {
+			if data, err = m.Keyring.DecryptChunk(fileID, i, info, data); err != nil {
+				return "", err
+			}
+		}
+
+		// فك الضغط يتم هنا فقط، القطع تبقى مضغوطة على القرص
+		data, err = DecompressChunk(info.Codec, data)
 		if err != nil {
-			break // Stop when the next chunk is missing
+			return "", fmt.Errorf("decode chunk %d of %s: %w", i, fileID, err)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return "", err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	actual := hex.EncodeToString(digest.Sum(nil))
+	if manifest.FileDigest != actual {
+		return "", &DigestMismatchError{
+			FileID:   fileID,
+			Expected: manifest.FileDigest,
+			Actual:   actual,
 		}
-		out.Write(data)
 	}
 
+	// الحجم الفعلي بعد فك الضغط
+	if err := reserved.Commit(); err != nil {
+		return "", err
+	}
+
+	if err := m.FS.Rename(partPath, finalPath); err != nil {
+		return "", err
+	}
+
+	done = true
 	return finalPath, nil
 }
 
+// fileHash returns the hash the client used for FileDigest:
+// keyed when the chunks are encrypted (see Keyring.FileHash).
+func (m *ChunkManager) fileHash(manifest *ChunkManifest) (hash.Hash, error) {
+	keyID := manifest.Chunks[0].KeyID
+	if keyID == "" {
+		return sha256.New(), nil
+	}
+	return m.Keyring.fileHash(keyID)
+}
+
+// AssembleFile rebuilds a file using the default manager.
+func AssembleFile(fileID string) (string, error) {
+	m, err := defaultManager()
+	if err != nil {
+		return "", err
+	}
+	return m.AssembleFile(fileID)
+}
+
 // Cleanup removes all temporary data related to a file.
 //
 // NOTE: This helps prevent disk space leaks.
+// The removed chunks no longer count against the disk quota.
 // TODO: Add retry or safety checks before deletion.
+func (m *ChunkManager) Cleanup(fileID string) {
+	if !validFileID(fileID) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cleanupLocked(fileID)
+}
+
+// CleanupStale removes the chunks of a file with no activity since cutoff
+// and reports whether it did.
+//
+// NOTE: The manifest is read again under m.mu, so a chunk stored after
+// the caller decided the upload was stale keeps it alive.
+func (m *ChunkManager) CleanupStale(fileID string, cutoff time.Time) (bool, error) {
+	if !validFileID(fileID) {
+		return false, ErrInvalidMessage
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lastActivity(fileID).After(cutoff) {
+		return false, nil
+	}
+
+	m.cleanupLocked(fileID)
+	return true, nil
+}
+
+// lastActivity returns the manifest UpdatedAt of a file, or the newest
+// file in its directory when there is no manifest (or an old one).
+//
+// NOTE: A file with nothing on disk returns the zero time.
+func (m *ChunkManager) lastActivity(fileID string) time.Time {
+	manifest, err := m.LoadManifest(fileID)
+	if err == nil && !manifest.UpdatedAt.IsZero() {
+		return manifest.UpdatedAt
+	}
+	return newestModTime(m.FS, m.chunkDir(fileID), "")
+}
+
+// cleanupLocked is Cleanup for callers that hold m.mu.
+func (m *ChunkManager) cleanupLocked(fileID string) {
+	tempDir := m.chunkDir(fileID)
+	m.FS.RemoveAll(tempDir)
+	m.Quota.Release(tempDir)
+}
+
+// Cleanup removes temporary data using the default manager.
 func Cleanup(fileID string) {
-	os.RemoveAll(filepath.Join("temp_chunks", fileID))
+	if m, err := defaultManager(); err == nil {
+		m.Cleanup(fileID)
+	}
+}
+
+// QuotaQueueName is the RabbitMQ queue used to read disk usage.
+const QuotaQueueName = "disk_quota_queue"
+
+// ServeQuotaUsage answers disk usage queries over RabbitMQ.
+func (m *ChunkManager) ServeQuotaUsage(rabbit *infra.RabbitClient) error {
+	return rabbit.ServeRPC(QuotaQueueName, func([]byte) ([]byte, error) {
+		usage, err := m.Quota.Usage()
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(usage)
+	})
 }
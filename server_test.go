@@ -0,0 +1,74 @@
+package lmgate
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"LM-Gate/internal/infra"
+)
+
+func TestValidateMessageRequiresFileDigest(t *testing.T) {
+	err := ValidateMessage(ChunkMessage{FileID: "abc", Total: 1, IsEOF: true})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("got %v, want ErrInvalidMessage", err)
+	}
+}
+
+func TestStoreChunkRejectsCorruptedChunk(t *testing.T) {
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+
+	msg := BuildChunkMessage("abc", 0, 1, []byte("capture"))
+	msg.Data = []byte("captura")
+	if err := m.StoreChunk(msg); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := m.FS.Stat(m.chunkPath("abc", 0)); err == nil {
+		t.Fatal("corrupted chunk written to disk")
+	}
+}
+
+func TestAssembleFileChecksDigest(t *testing.T) {
+	data := []byte("capture bytes")
+
+	tests := []struct {
+		name   string
+		digest string
+		ok     bool
+	}{
+		{"matching digest", hashHex(sha256.New(), data), true},
+		{"wrong digest", hashHex(sha256.New(), []byte("other")), false},
+		{"no digest", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+			if err := m.StoreChunk(BuildChunkMessage("abc", 0, 1, data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.recordEOF(ChunkMessage{FileID: "abc", Total: 1, IsEOF: true, FileDigest: tt.digest}); err != nil {
+				t.Fatal(err)
+			}
+
+			path, err := m.AssembleFile("abc")
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, _ := m.FS.ReadFile(path); string(got) != string(data) {
+					t.Fatalf("assembled %q", got)
+				}
+				return
+			}
+
+			var mismatch *DigestMismatchError
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("got %v, want DigestMismatchError", err)
+			}
+			if _, err := m.FS.Stat(m.capturePath("abc")); err == nil {
+				t.Fatal("bad capture moved into the upload dir")
+			}
+		})
+	}
+}
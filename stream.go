@@ -0,0 +1,136 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/infra"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultStreamName is the file name sent for stdin uploads.
+const defaultStreamName = "stdin.pcap"
+
+// uploadStdin runs the root command for "LM -".
+func uploadStdin(cmd *cobra.Command) error {
+	name, _ := cmd.Flags().GetString("name")
+
+	fmt.Println("Uploading from stdin as:", name)
+
+	sender, closeSender, err := newSender(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeSender()
+
+	opts, err := uploadOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	sent, err := UploadStream(os.Stdin, name, opts, sender)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Chunks sent:", sent)
+	fmt.Println("Done.")
+	return nil
+}
+
+// GenerateStreamID creates a random identifier for a stream upload.
+//
+// NOTE: The content hash of a stream is only known at the end,
+// so streams cannot use GenerateFileID.
+func GenerateStreamID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "stream-" + hex.EncodeToString(b), nil
+}
+
+// UploadStream uploads data of unknown length, e.g. tcpdump -w -.
+//
+// Steps:
+// 1. Read chunks from r and send them with Total 0 (unknown).
+// 2. When r ends, send EOF with the final Total and the SHA-256 digest.
+//
+// NOTE: Nothing is staged on disk, memory stays around
+// ChunkSize × (Parallelism + 1). Resume and SkipExisting are ignored,
+// a stream cannot be read twice. The capture is stored under the
+// stream ID, so it is not deduplicated against file uploads.
+func UploadStream(r io.Reader, name string, opts UploadOptions, sender Sender) (int, error) {
+	if sender == nil {
+		return 0, errors.New("sender is nil")
+	}
+
+	if err := validateChunkSize(opts.ChunkSize); err != nil {
+		return 0, err
+	}
+
+	if opts.Parallelism < 1 {
+		opts.Parallelism = 1
+	}
+
+	fileID, err := GenerateStreamID()
+	if err != nil {
+		return 0, err
+	}
+
+	codec, err := negotiateCodec(opts.Codec, sender)
+	if err != nil {
+		return 0, err
+	}
+
+	var fileKey *FileKey
+	if opts.Keyring != nil {
+		if fileKey, err = opts.Keyring.NewFileKey(fileID); err != nil {
+			return 0, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var tuner *chunkTuner
+	if opts.Adaptive {
+		tuner = newChunkTuner(opts.ChunkSize)
+	}
+
+	digest := opts.Keyring.FileHash()
+	chunks, readErrs := readChunks(ctx, io.TeeReader(r, digest), opts.ChunkSize, tuner)
+
+	up := chunkUpload{
+		fileID:  fileID,
+		total:   0, // غير معروف حتى نهاية الـ stream
+		codec:   codec,
+		fileKey: fileKey,
+		limiter: infra.NewRateLimiter(opts.RateLimit),
+		tuner:   tuner,
+	}
+	sent, _, err := up.run(ctx, cancel, chunks, opts.Parallelism, sender)
+	if err != nil {
+		return sent, err
+	}
+
+	if err := <-readErrs; err != nil {
+		return sent, err
+	}
+
+	if sent == 0 {
+		return 0, fmt.Errorf("%w: stream ended before any data", ErrEmptyFile)
+	}
+
+	fileDigest := hex.EncodeToString(digest.Sum(nil))
+	if err := SendEOF(fileID, name, sent, fileDigest, sender); err != nil {
+		return sent, err
+	}
+
+	return sent, nil
+}
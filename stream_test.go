@@ -0,0 +1,68 @@
+package lmgate
+
+import (
+	"LM-Gate/internal/infra"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestUploadStreamSendsTotalAtEOF(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 5)
+	sender := &recordingSender{}
+
+	// io.MultiReader يخفي الطول مثل stdin
+	sent, err := UploadStream(io.MultiReader(bytes.NewReader(data)), "live.pcap", UploadOptions{ChunkSize: 16}, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 4 || len(sender.msgs) != 5 {
+		t.Fatalf("sent %d chunks in %d messages, want 4 in 5", sent, len(sender.msgs))
+	}
+
+	eof := sender.msgs[len(sender.msgs)-1]
+	for _, msg := range sender.msgs[:sent] {
+		if msg.IsEOF || msg.Total != 0 {
+			t.Fatalf("chunk %d: EOF %t, Total %d before the end", msg.ChunkID, msg.IsEOF, msg.Total)
+		}
+		if msg.FileID != eof.FileID {
+			t.Fatalf("chunk %d has file ID %s, EOF %s", msg.ChunkID, msg.FileID, eof.FileID)
+		}
+	}
+	if !eof.IsEOF || eof.Total != 4 || eof.FileName != "live.pcap" {
+		t.Fatalf("EOF %+v, want Total 4 and name live.pcap", eof)
+	}
+	if want := hashHex(sha256.New(), data); eof.FileDigest != want {
+		t.Fatalf("digest %s, want %s", eof.FileDigest, want)
+	}
+
+	// السيرفر يقبل الأجزاء بدون Total ويجمع الملف عند EOF
+	m := NewChunkManager(infra.NewMemFileSystem(), "tmp", "up")
+	var path string
+	for _, msg := range sender.msgs {
+		if path, err = m.OnMessage(msg); err != nil {
+			t.Fatalf("chunk %d: %v", msg.ChunkID, err)
+		}
+	}
+	got, err := m.FS.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("assembled %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestUploadStreamRejectsEmptyStream(t *testing.T) {
+	sender := &recordingSender{}
+
+	_, err := UploadStream(bytes.NewReader(nil), "live.pcap", UploadOptions{ChunkSize: 16}, sender)
+	if !errors.Is(err, ErrEmptyFile) {
+		t.Fatalf("got %v, want ErrEmptyFile", err)
+	}
+	if len(sender.msgs) != 0 {
+		t.Fatalf("sent %d messages for an empty stream", len(sender.msgs))
+	}
+}
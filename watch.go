@@ -0,0 +1,343 @@
+package lmgate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultWatchInterval is how often the watched directory is scanned.
+	defaultWatchInterval = 5 * time.Second
+
+	// defaultStableFor is how long a file must keep the same size
+	// and modification time before it is uploaded.
+	defaultStableFor = 30 * time.Second
+
+	// defaultWatchPattern matches tcpdump output, including -C
+	// rotation suffixes (capture.pcap1, capture.pcap2, ...).
+	defaultWatchPattern = "*.pcap*"
+
+	// watchStateFile is the default sent-state file inside the watched directory.
+	watchStateFile = ".lm-watch.json"
+)
+
+// watchCmd defines the CLI command: LM watch <dir>
+//
+// NOTE: Meant for sensors running tcpdump -G / -C. Every rotated file
+// is uploaded once it stops growing.
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir>",
+	Short: "Upload new capture files from a directory as they are completed",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			return errors.New("directory does not exist")
+		}
+		if !info.IsDir() {
+			return errors.New("path is not a directory")
+		}
+
+		sender, closeSender, err := newSender(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeSender()
+
+		opts, err := uploadOptions(cmd)
+		if err != nil {
+			return err
+		}
+
+		w := NewWatcher(dir, opts, sender)
+		w.Interval, _ = cmd.Flags().GetDuration("interval")
+		w.StableFor, _ = cmd.Flags().GetDuration("stable-for")
+		w.Pattern, _ = cmd.Flags().GetString("pattern")
+		if state, _ := cmd.Flags().GetString("state"); state != "" {
+			w.StatePath = state
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		fmt.Println("Watching:", dir)
+		return w.Run(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	addUploadFlags(watchCmd)
+	watchCmd.Flags().Duration("interval", defaultWatchInterval, "how often the directory is scanned")
+	watchCmd.Flags().Duration("stable-for", defaultStableFor, "how long a file must stop growing before it is uploaded")
+	watchCmd.Flags().String("pattern", defaultWatchPattern, "glob of file names to upload")
+	watchCmd.Flags().String("state", "", "sent-state file (default <dir>/"+watchStateFile+")")
+}
+
+// Watcher uploads completed capture files found in a directory.
+//
+// NOTE: The directory is polled, no OS notifications are needed.
+// A file is considered complete when its size and modification time
+// did not change for StableFor. Files that fail to upload are retried
+// on the next scan.
+type Watcher struct {
+	Dir       string
+	Pattern   string
+	Interval  time.Duration
+	StableFor time.Duration
+	StatePath string
+
+	Options UploadOptions
+	Sender  Sender
+
+	// pending tracks files that are not stable yet.
+	pending map[string]pendingFile
+}
+
+// pendingFile is the last observed state of a file.
+type pendingFile struct {
+	Size    int64
+	ModTime time.Time
+	Since   time.Time // when this size was first seen
+}
+
+// NewWatcher creates a watcher with the default interval and pattern.
+func NewWatcher(dir string, opts UploadOptions, sender Sender) *Watcher {
+	return &Watcher{
+		Dir:       dir,
+		Pattern:   defaultWatchPattern,
+		Interval:  defaultWatchInterval,
+		StableFor: defaultStableFor,
+		StatePath: filepath.Join(dir, watchStateFile),
+		Options:   opts,
+		Sender:    sender,
+		pending:   make(map[string]pendingFile),
+	}
+}
+
+// Run scans the directory every Interval until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.Interval <= 0 {
+		return errors.New("watch interval must be positive")
+	}
+
+	if _, err := filepath.Match(w.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", w.Pattern, err)
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Scan(time.Now()); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan runs one pass over the directory and uploads stable files.
+//
+// NOTE: Only state errors stop the watcher; upload errors are
+// printed and the file is tried again on the next scan.
+func (w *Watcher) Scan(now time.Time) error {
+	state, err := LoadWatchState(w.StatePath)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range w.candidates() {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			delete(w.pending, path)
+			continue
+		}
+
+		if state.Sent(path, info) {
+			delete(w.pending, path)
+			continue
+		}
+
+		if !w.stable(path, info, now) {
+			continue
+		}
+
+		fmt.Println("Uploading:", path)
+		result, err := UploadFileResult(path, w.Options, w.Sender)
+		if err != nil {
+			fmt.Printf("Warning: upload of %s failed, will retry: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("Done: %s (%d chunks sent)\n", path, result.Sent)
+
+		// الحالة تُحفظ بعد كل ملف، إعادة التشغيل لا تعيد رفع ما أُرسل
+		state.Record(path, info, result.FileID)
+		if err := state.Save(w.StatePath); err != nil {
+			return err
+		}
+		delete(w.pending, path)
+	}
+
+	return nil
+}
+
+// candidates lists matching files in upload order (oldest first).
+//
+// NOTE: Hidden files are skipped, which also skips the state file.
+func (w *Watcher) candidates() []string {
+	entries, err := os.ReadDir(w.Dir)
+	if err != nil {
+		fmt.Println("Warning: cannot read directory:", err)
+		return nil
+	}
+
+	type candidate struct {
+		path    string
+		modTime time.Time
+	}
+
+	var list []candidate
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ok, _ := filepath.Match(w.Pattern, name); !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, candidate{filepath.Join(w.Dir, name), info.ModTime()})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].modTime.Before(list[j].modTime)
+	})
+
+	paths := make([]string, len(list))
+	for i, c := range list {
+		paths[i] = c.path
+	}
+	return paths
+}
+
+// stable reports whether a file kept its size and modification time
+// for at least StableFor.
+func (w *Watcher) stable(path string, info os.FileInfo, now time.Time) bool {
+	if w.pending == nil {
+		w.pending = make(map[string]pendingFile)
+	}
+
+	p, ok := w.pending[path]
+	if !ok || p.Size != info.Size() || !p.ModTime.Equal(info.ModTime()) {
+		// أول مرة أو ما زال الملف يكبر
+		w.pending[path] = pendingFile{
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Since:   now,
+		}
+		return false
+	}
+
+	return info.Size() > 0 && now.Sub(p.Since) >= w.StableFor
+}
+
+// WatchState records which files the watcher already uploaded.
+//
+// NOTE: A file is identified by path, size and modification time,
+// so a rotated file reusing an old name is uploaded again.
+// If the process dies between upload and Save, the file is sent again
+// on restart; the server skips it because its content hash is known.
+type WatchState struct {
+	Files map[string]SentFile `json:"files"`
+}
+
+// SentFile is one uploaded file.
+type SentFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	FileID  string    `json:"file_id"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// LoadWatchState reads the state file (empty state if missing).
+func LoadWatchState(path string) (*WatchState, error) {
+	state := &WatchState{Files: make(map[string]SentFile)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("corrupted watch state %s: %w", path, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]SentFile)
+	}
+
+	return state, nil
+}
+
+// Sent reports whether this version of the file was already uploaded.
+func (s *WatchState) Sent(path string, info os.FileInfo) bool {
+	f, ok := s.Files[path]
+	return ok && f.Size == info.Size() && f.ModTime.Equal(info.ModTime())
+}
+
+// Record marks a file as uploaded.
+func (s *WatchState) Record(path string, info os.FileInfo, fileID string) {
+	s.Files[path] = SentFile{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		FileID:  fileID,
+		SentAt:  time.Now().UTC(),
+	}
+}
+
+// Save writes the state file (temp file + rename).
+//
+// NOTE: Entries for files that no longer exist are dropped,
+// so the state does not grow forever with rotated-away captures.
+func (s *WatchState) Save(path string) error {
+	for p := range s.Files {
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			delete(s.Files, p)
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,51 @@
+package lmgate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherRecordsUploadFileID(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rotated.pcap")
+	if err := os.WriteFile(path, []byte("captured packets"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &recordingSender{}
+	w := NewWatcher(dir, UploadOptions{ChunkSize: 1024, Keyring: testKeyring(t)}, sender)
+	w.StableFor = time.Second
+
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(2 * time.Second)} {
+		if err := w.Scan(at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sender.msgs) == 0 {
+		t.Fatal("stable file was not uploaded")
+	}
+
+	state, err := LoadWatchState(w.StatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// الـ ID المحفوظ هو نفسه المرسل (مفتاحي عند التشفير)
+	if got, want := state.Files[path].FileID, sender.msgs[0].FileID; got != want {
+		t.Fatalf("state FileID %s, upload FileID %s", got, want)
+	}
+}
+
+func TestUploadFileResultFileID(t *testing.T) {
+	path := writeTempFile(t, "cap.pcap", []byte("packets"))
+
+	result, err := UploadFileResult(path, UploadOptions{ChunkSize: 1024}, &recordingSender{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FileID != GenerateFileID(path) || result.Sent != 1 {
+		t.Fatalf("got %+v", result)
+	}
+}
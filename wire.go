@@ -0,0 +1,175 @@
+package lmgate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Binary wire format for ChunkMessage.
+//
+// Layout (big endian):
+//
+//	magic    [4]byte  "LMCH"
+//	version  uint8    WireVersion
+//	flags    uint8    bit 0 = IsEOF
+//	chunkID  uint32
+//	total    uint32
+//	fileID, checksum, rawChecksum, codec, keyID   (uint16 length + bytes)
+//	fileDigest, fileName                          (uint16 length + bytes)
+//	wrappedKey                                    (uint16 length + bytes)
+//	data                                          (uint32 length + bytes)
+//
+// NOTE: Data is carried raw, unlike JSON which base64-encodes it.
+// Any change to the layout must bump WireVersion.
+
+const (
+	// WireVersion is the current binary format version.
+	WireVersion = 1
+
+	// WireContentType is the AMQP content type of encoded chunk messages.
+	WireContentType = "application/vnd.lmgate.chunk"
+
+	// wireHeaderSize is the fixed part before the variable fields.
+	wireHeaderSize = 4 + 1 + 1 + 4 + 4
+
+	flagEOF = 1 << 0
+)
+
+var wireMagic = [4]byte{'L', 'M', 'C', 'H'}
+
+var (
+	ErrWireFormat  = errors.New("invalid chunk wire format")
+	ErrWireVersion = errors.New("unsupported chunk wire version")
+)
+
+// EncodeChunkMessage serializes a message into the binary wire format.
+//
+// NOTE: The same function is used by the client and the server.
+func EncodeChunkMessage(msg ChunkMessage) ([]byte, error) {
+	if msg.ChunkID < 0 || msg.Total < 0 || int64(msg.ChunkID) > math.MaxUint32 || int64(msg.Total) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: chunk id or total out of range", ErrWireFormat)
+	}
+	if int64(len(msg.Data)) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: data too large", ErrWireFormat)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(wireHeaderSize + 512 + len(msg.Data))
+
+	var flags uint8
+	if msg.IsEOF {
+		flags |= flagEOF
+	}
+
+	buf.Write(wireMagic[:])
+	buf.WriteByte(WireVersion)
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, uint32(msg.ChunkID))
+	binary.Write(&buf, binary.BigEndian, uint32(msg.Total))
+
+	fields := [][]byte{
+		[]byte(msg.FileID),
+		[]byte(msg.Checksum),
+		[]byte(msg.RawChecksum),
+		[]byte(msg.Codec),
+		[]byte(msg.KeyID),
+		[]byte(msg.FileDigest),
+		[]byte(msg.FileName),
+		msg.WrappedKey,
+	}
+	for _, f := range fields {
+		if len(f) > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: header field too long", ErrWireFormat)
+		}
+		binary.Write(&buf, binary.BigEndian, uint16(len(f)))
+		buf.Write(f)
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(msg.Data)))
+	buf.Write(msg.Data)
+
+	return buf.Bytes(), nil
+}
+
+// DecodeChunkMessage parses a message from the binary wire format.
+//
+// NOTE: Messages with another WireVersion are rejected with ErrWireVersion.
+// Data aliases the input buffer.
+func DecodeChunkMessage(b []byte) (ChunkMessage, error) {
+	var msg ChunkMessage
+
+	if len(b) < wireHeaderSize || !bytes.Equal(b[:4], wireMagic[:]) {
+		return msg, ErrWireFormat
+	}
+
+	if v := b[4]; v != WireVersion {
+		return msg, fmt.Errorf("%w: got %d, want %d", ErrWireVersion, v, WireVersion)
+	}
+
+	msg.IsEOF = b[5]&flagEOF != 0
+	msg.ChunkID = int(binary.BigEndian.Uint32(b[6:10]))
+	msg.Total = int(binary.BigEndian.Uint32(b[10:14]))
+
+	d := wireDecoder{rest: b[wireHeaderSize:]}
+	msg.FileID = string(d.next(2))
+	msg.Checksum = string(d.next(2))
+	msg.RawChecksum = string(d.next(2))
+	msg.Codec = string(d.next(2))
+	msg.KeyID = string(d.next(2))
+	msg.FileDigest = string(d.next(2))
+	msg.FileName = string(d.next(2))
+	msg.WrappedKey = d.next(2)
+	msg.Data = d.next(4)
+
+	if d.err != nil {
+		return ChunkMessage{}, d.err
+	}
+
+	if len(d.rest) != 0 {
+		return ChunkMessage{}, fmt.Errorf("%w: %d trailing bytes", ErrWireFormat, len(d.rest))
+	}
+
+	return msg, nil
+}
+
+// wireDecoder reads length-prefixed fields and keeps the first error.
+type wireDecoder struct {
+	rest []byte
+	err  error
+}
+
+// next reads one field whose length prefix is prefixSize bytes (2 or 4).
+func (d *wireDecoder) next(prefixSize int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if len(d.rest) < prefixSize {
+		d.err = fmt.Errorf("%w: truncated message", ErrWireFormat)
+		return nil
+	}
+
+	var n int
+	if prefixSize == 2 {
+		n = int(binary.BigEndian.Uint16(d.rest))
+	} else {
+		n = int(binary.BigEndian.Uint32(d.rest))
+	}
+	d.rest = d.rest[prefixSize:]
+
+	if n > len(d.rest) {
+		d.err = fmt.Errorf("%w: truncated message", ErrWireFormat)
+		return nil
+	}
+
+	v := d.rest[:n:n]
+	d.rest = d.rest[n:]
+
+	if n == 0 {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,136 @@
+package lmgate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+	full := ChunkMessage{
+		FileID:      "abc",
+		ChunkID:     3,
+		Total:       7,
+		Checksum:    "sum",
+		RawChecksum: "raw",
+		Codec:       CodecGzip,
+		KeyID:       "k1",
+		WrappedKey:  []byte{1, 2, 3},
+		Data:        []byte("payload"),
+	}
+	eof := ChunkMessage{FileID: "abc", Total: 7, IsEOF: true, FileDigest: "digest", FileName: "cap.pcap"}
+
+	tests := []struct {
+		name string
+		msg  ChunkMessage
+	}{
+		{"chunk", full},
+		{"eof", eof},
+		{"empty fields", ChunkMessage{FileID: "abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := EncodeChunkMessage(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b[4] != WireVersion {
+				t.Fatalf("version byte %d, want %d", b[4], WireVersion)
+			}
+
+			got, err := DecodeChunkMessage(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Fatalf("got %+v, want %+v", got, tt.msg)
+			}
+		})
+	}
+}
+
+// Uploader يحدده السيرفر، فلا يُرسل أبداً
+func TestWireDropsUploader(t *testing.T) {
+	b, err := EncodeChunkMessage(ChunkMessage{FileID: "abc", Uploader: "sensor-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeChunkMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Uploader != "" {
+		t.Fatalf("uploader %q went over the wire", got.Uploader)
+	}
+}
+
+func TestWireDecodeErrors(t *testing.T) {
+	valid, err := EncodeChunkMessage(ChunkMessage{FileID: "abc", ChunkID: 1, Total: 2, Data: []byte("payload")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withVersion := func(v byte) []byte {
+		b := bytes.Clone(valid)
+		b[4] = v
+		return b
+	}
+
+	// طول البيانات أكبر من الرسالة
+	oversized := bytes.Clone(valid)
+	binary.BigEndian.PutUint32(oversized[len(oversized)-len("payload")-4:], 1<<20)
+
+	// طول حقل FileID أكبر من الرسالة
+	oversizedField := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(oversizedField[wireHeaderSize:], 0xFFFF)
+
+	tests := []struct {
+		name string
+		in   []byte
+		want error
+	}{
+		{"empty", nil, ErrWireFormat},
+		{"truncated header", valid[:wireHeaderSize-1], ErrWireFormat},
+		{"header only", valid[:wireHeaderSize], ErrWireFormat},
+		{"truncated data", valid[:len(valid)-1], ErrWireFormat},
+		{"bad magic", append([]byte("LMCX"), valid[4:]...), ErrWireFormat},
+		{"version 0", withVersion(0), ErrWireVersion},
+		{"future version", withVersion(WireVersion + 1), ErrWireVersion},
+		{"oversized data length", oversized, ErrWireFormat},
+		{"oversized field length", oversizedField, ErrWireFormat},
+		{"trailing bytes", append(bytes.Clone(valid), 0), ErrWireFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeChunkMessage(tt.in)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWireEncodeLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  ChunkMessage
+	}{
+		{"negative chunk id", ChunkMessage{FileID: "abc", ChunkID: -1}},
+		{"negative total", ChunkMessage{FileID: "abc", Total: -1}},
+		{"field too long", ChunkMessage{FileID: strings.Repeat("a", 1<<16)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncodeChunkMessage(tt.msg); !errors.Is(err, ErrWireFormat) {
+				t.Fatalf("got %v, want ErrWireFormat", err)
+			}
+		})
+	}
+}